@@ -21,14 +21,15 @@
 package starx
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/lonnng/starx/cluster"
 	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/log"
-	routelib "github.com/lonnng/starx/route"
 	"github.com/lonnng/starx/session"
 )
 
@@ -39,10 +40,14 @@ type acceptor struct {
 	id         int64
 	socket     net.Conn
 	status     networkStatus
+	mu         sync.RWMutex               // protect session maps, which are accessed by broadcasts
 	sessionMap map[int64]*session.Session // backend sessions
 	f2bMap     map[int64]int64            // frontend session id -> backend session id map
 	b2fMap     map[int64]int64            // backend session id -> frontend session id map
 	lastTime   int64                      // last heartbeat unix time stamp
+	tasks      chan func()                // tasks executed in the goroutine of handling requests
+	die        chan bool                  // closed when acceptor closed
+	server     atomic.Value               // id of the remote server, reported after connected
 }
 
 // Create new backend session instance
@@ -55,6 +60,8 @@ func newAcceptor(id int64, conn net.Conn) *acceptor {
 		f2bMap:     make(map[int64]int64),
 		b2fMap:     make(map[int64]int64),
 		lastTime:   time.Now().Unix(),
+		tasks:      make(chan func(), packetBufferSize),
+		die:        make(chan bool),
 	}
 }
 
@@ -71,25 +78,81 @@ func (a *acceptor) heartbeat() {
 }
 
 func (a *acceptor) Session(sid int64) *session.Session {
+	a.mu.Lock()
 	if bsid, ok := a.f2bMap[sid]; ok && bsid > 0 {
-		return a.sessionMap[bsid]
+		s := a.sessionMap[bsid]
+		a.mu.Unlock()
+		return s
 	}
 	s := session.New(a)
+	atomic.AddInt64(&backendSessions, 1)
 	a.sessionMap[s.ID] = s
 	a.f2bMap[sid] = s.ID
 	a.b2fMap[s.ID] = sid
+	a.mu.Unlock()
+
+	sessionCreated(s)
 	return s
 }
 
+// frontendSid returns the frontend session id of the backend session
+func (a *acceptor) frontendSid(bsid int64) (int64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	sid, ok := a.b2fMap[bsid]
+	return sid, ok
+}
+
+// removeSession remove the backend session, returns false when not exists
+func (a *acceptor) removeSession(bsid int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.sessionMap[bsid]; !ok {
+		return false
+	}
+	delete(a.sessionMap, bsid)
+	if fid, ok := a.b2fMap[bsid]; ok {
+		delete(a.b2fMap, bsid)
+		delete(a.f2bMap, fid)
+	}
+	return true
+}
+
+func (a *acceptor) sessions() []*session.Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	sessions := make([]*session.Session, 0, len(a.sessionMap))
+	for _, s := range a.sessionMap {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
 func (a *acceptor) Close() {
 	a.status = statusClosed
-	for _, s := range a.sessionMap {
+	close(a.die)
+	for _, s := range a.sessions() {
+		s.SetCloseReason(session.CloseReasonClientEOF)
 		transporter.closeSession(s)
 	}
 	transporter.removeAcceptor(a)
+	directory.removeAcceptor(a)
+	detachMembers(a)
 	a.socket.Close()
 }
 
+// schedule the task to be executed in the goroutine of handling requests,
+// the task will be discarded after acceptor closed
+func (a *acceptor) schedule(fn func()) {
+	select {
+	case a.tasks <- fn:
+	case <-a.die:
+	}
+}
+
 func (a *acceptor) ID() int64 {
 	return a.id
 }
@@ -113,7 +176,7 @@ func (a *acceptor) Push(session *session.Session, route string, v interface{}) e
 		return err
 	}
 
-	sid, ok := rs.b2fMap[session.ID]
+	sid, ok := rs.frontendSid(session.ID)
 	if !ok {
 		log.Errorf("sid not exists")
 		return ErrSidNotExists
@@ -128,6 +191,29 @@ func (a *acceptor) Push(session *session.Session, route string, v interface{}) e
 	return rpc.WriteResponse(a.socket, resp)
 }
 
+// serverID returns id of the remote server, empty string will be returned
+// when the remote server has not reported
+func (a *acceptor) serverID() string {
+	id, _ := a.server.Load().(string)
+	return id
+}
+
+// Push message to multiple frontend sessions, all frontend session ids will
+// be sent to frontend server in a single response
+func (a *acceptor) multicast(fsids []int64, route string, data []byte) error {
+	if len(fsids) == 0 {
+		return ErrSidNotExists
+	}
+
+	resp := &rpc.Response{
+		Route: route,
+		Kind:  rpc.RemotePush,
+		Data:  data,
+		Sids:  fsids,
+	}
+	return rpc.WriteResponse(a.socket, resp)
+}
+
 // Response message to session
 func (a *acceptor) Response(session *session.Session, v interface{}) error {
 	data, err := serializeOrRaw(v)
@@ -143,7 +229,7 @@ func (a *acceptor) Response(session *session.Session, v interface{}) error {
 		return err
 	}
 
-	sid, ok := rs.b2fMap[session.ID]
+	sid, ok := rs.frontendSid(session.ID)
 	if !ok {
 		log.Errorf("sid not exists")
 		return ErrSidNotExists
@@ -156,25 +242,39 @@ func (a *acceptor) Response(session *session.Session, v interface{}) error {
 	return rpc.WriteResponse(a.socket, resp)
 }
 
-func (a *acceptor) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
-	r, err := routelib.Decode(route)
-	if err != nil {
+// Bind uid to backend session and push to frontend session
+func (a *acceptor) Bind(session *session.Session, uid int64) error {
+	if err := transporter.bind(session, uid); err != nil {
 		return err
 	}
+	return a.Sync(session)
+}
 
-	if app.config.Type == r.ServerType {
-		return ErrRPCLocal
-	}
-
-	data, err := gobEncode(args...)
+// Sync push backend session state to frontend session
+func (a *acceptor) Sync(session *session.Session) error {
+	data, err := session.SyncData()
 	if err != nil {
 		return err
 	}
 
-	ret, err := cluster.Call(rpc.User, r, session, data)
-	if err != nil {
-		return err
+	sid, ok := a.frontendSid(session.ID)
+	if !ok {
+		log.Errorf("sid not exists")
+		return ErrSidNotExists
+	}
+
+	resp := &rpc.Response{
+		Kind: rpc.SessionSync,
+		Data: data,
+		Sid:  sid,
 	}
+	return rpc.WriteResponse(a.socket, resp)
+}
+
+func (a *acceptor) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
+	return callRemote(context.Background(), session, route, reply, args...)
+}
 
-	return gobDecode(reply, ret)
+func (a *acceptor) CallContext(ctx context.Context, session *session.Session, route string, reply interface{}, args ...interface{}) error {
+	return callRemote(ctx, session, route, reply, args...)
 }
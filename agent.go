@@ -21,16 +21,17 @@
 package starx
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/lonnng/starx/cluster"
-	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/log"
 	"github.com/lonnng/starx/packet"
-	routelib "github.com/lonnng/starx/route"
 	"github.com/lonnng/starx/session"
 )
 
@@ -38,32 +39,70 @@ var (
 	ErrRPCLocal          = errors.New("RPC object must location in different server type")
 	ErrSidNotExists      = errors.New("sid not exists")
 	ErrSendChannelClosed = errors.New("agent send channel closed")
+	ErrSendBufferFull    = errors.New("agent send buffer full, message dropped")
+	ErrNotAgentSession   = errors.New("session is not a frontend session")
 )
 
+// OverflowPolicy represents the behavior when the send buffer of a frontend
+// session is full, which usually caused by a slow client
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // block until timeout, drop the message after timeout, broadcasts are dropped without blocking
+	OverflowDropOldest                       // drop the oldest pending message
+	OverflowDropNewest                       // drop the message being sent
+	OverflowKick                             // close the slow client
+)
+
+// dropped represents the total amount of messages dropped in current server
+var dropped int64
+
+// overflowConfig represents the overflow policy of an agent
+type overflowConfig struct {
+	policy  OverflowPolicy
+	timeout time.Duration // block timeout of OverflowBlock, zero represents forever
+}
+
 // Agent corresponding a user, used for store raw socket information
 // only used in package internal, can not accessible by other package
 type agent struct {
 	id         int64
 	socket     net.Conn
-	status     networkStatus
+	status     int32 // networkStatus, accessed atomically
 	session    *session.Session
 	sendBuffer chan []byte
 	recvBuffer chan *packet.Packet
+	tasks      chan func() // tasks executed in the goroutine of handling messages
 	die        chan bool
-	lastTime   int64 // last heartbeat unix time stamp
+	lastTime   int64             // last heartbeat unix time stamp
+	conn       *session.ConnInfo // connection information
+
+	overflowConfig atomic.Value // *overflowConfig, behavior when send buffer is full
 }
 
 // Create new agent instance
 func newAgent(conn net.Conn) *agent {
 	a := &agent{
 		socket:     conn,
-		status:     statusStart,
+		status:     int32(statusStart),
 		lastTime:   time.Now().Unix(),
 		sendBuffer: make(chan []byte, packetBufferSize),
 		recvBuffer: make(chan *packet.Packet, packetBufferSize),
+		tasks:      make(chan func(), packetBufferSize),
 		die:        make(chan bool, 1),
+		conn: &session.ConnInfo{
+			RemoteAddr:  conn.RemoteAddr().String(),
+			Transport:   "tcp",
+			ConnectedAt: time.Now(),
+		},
 	}
+	if _, ok := conn.(*wsConn); ok {
+		a.conn.Transport = "websocket"
+	}
+	a.setOverflowPolicy(env.overflowPolicy, env.overflowTimeout)
+
 	s := session.New(a)
+	s.SetConnInfo(a.conn)
 	a.session = s
 	a.id = s.ID
 
@@ -82,13 +121,42 @@ func (a *agent) heartbeat() {
 	a.lastTime = time.Now().Unix()
 }
 
+func (a *agent) getStatus() networkStatus {
+	return networkStatus(atomic.LoadInt32(&a.status))
+}
+
+// setStatus set the status of agent, false will be returned when the agent
+// has been closed
+func (a *agent) setStatus(status networkStatus) bool {
+	for {
+		old := atomic.LoadInt32(&a.status)
+		if networkStatus(old) == statusClosed {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&a.status, old, int32(status)) {
+			return true
+		}
+	}
+}
+
+func (a *agent) setOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	a.overflowConfig.Store(&overflowConfig{policy: policy, timeout: timeout})
+}
+
+// Close agent by application
 func (a *agent) Close() {
-	if a.status == statusClosed {
+	a.close(session.CloseReasonServerClose)
+}
+
+// close agent with reason, the reason will be recorded in session
+func (a *agent) close(reason session.CloseReason) {
+	// closed by another goroutine
+	if !a.setStatus(statusClosed) {
 		return
 	}
 
-	a.status = statusClosed
-	log.Debugf("Session closed, Id=%d, IP=%s", a.session.ID, a.socket.RemoteAddr())
+	a.session.SetCloseReason(reason)
+	log.Debugf("Session closed, Id=%d, IP=%s, Reason=%s", a.session.ID, a.socket.RemoteAddr(), reason)
 
 	a.die <- true
 
@@ -101,26 +169,129 @@ func (a *agent) Close() {
 	a.socket.Close()
 }
 
+// schedule the task to be executed in the goroutine of handling messages,
+// the task will be discarded after agent closed
+func (a *agent) schedule(fn func()) {
+	select {
+	case a.tasks <- fn:
+	case <-a.die:
+	}
+}
+
 func (a *agent) ID() int64 {
 	return a.id
 }
 
-func (a *agent) Send(data []byte) (err error) {
+func (a *agent) Send(data []byte) error {
+	return a.send(data, true)
+}
+
+// sendNonBlocking send data without blocking on full send buffer, which is
+// used by broadcasts, so a slow client never stalls the others, the message
+// is dropped instead of blocking with OverflowBlock policy
+func (a *agent) sendNonBlocking(data []byte) error {
+	return a.send(data, false)
+}
+
+func (a *agent) send(data []byte, block bool) (err error) {
 	defer func() {
-		if e := recover(); err != nil {
-			if er, ok := e.(error); ok {
-				err = er
-			}
+		// send buffer closed by another goroutine
+		if e := recover(); e != nil {
+			err = ErrSendChannelClosed
+		}
+	}()
+
+	if err := a.trySend(data); err != ErrSendBufferFull {
+		return err
+	}
+	return a.overflow(data, block)
+}
+
+// trySend send data only when send buffer is not full, overflow policy is not
+// applied, ErrSendBufferFull will be returned instead
+func (a *agent) trySend(data []byte) (err error) {
+	defer func() {
+		// send buffer closed by another goroutine
+		if e := recover(); e != nil {
+			err = ErrSendChannelClosed
 		}
 	}()
 
-	if a.status < statusClosed {
-		a.sendBuffer <- data
+	if a.getStatus() >= statusClosed {
+		return ErrSendChannelClosed
+	}
+
+	select {
+	case a.sendBuffer <- data:
 		return nil
+	default:
+		return ErrSendBufferFull
 	}
+}
+
+// overflow handle the message when send buffer is full with overflow policy,
+// OverflowBlock policy drops the message immediately when block is false
+func (a *agent) overflow(data []byte, block bool) error {
+	cfg := a.overflowConfig.Load().(*overflowConfig)
+	switch cfg.policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case m := <-a.sendBuffer:
+				// close request should not be dropped
+				if m == nil {
+					go a.close(a.session.CloseReason())
+					return ErrSendChannelClosed
+				}
+				a.dropped()
+			default:
+			}
+
+			select {
+			case a.sendBuffer <- data:
+				return nil
+			default:
+			}
+		}
 
-	err = ErrSendChannelClosed
-	return
+	case OverflowDropNewest:
+		a.dropped()
+		return ErrSendBufferFull
+
+	case OverflowKick:
+		a.dropped()
+		log.Infof("Session send buffer overflow, Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
+		// closed asynchronously, the caller may hold transporter lock
+		go a.close(session.CloseReasonSlowConsumer)
+		return ErrSendBufferFull
+
+	default:
+		if !block {
+			a.dropped()
+			return ErrSendBufferFull
+		}
+
+		if cfg.timeout <= 0 {
+			a.sendBuffer <- data
+			return nil
+		}
+
+		timer := time.NewTimer(cfg.timeout)
+		defer timer.Stop()
+
+		select {
+		case a.sendBuffer <- data:
+			return nil
+		case <-timer.C:
+			a.dropped()
+			return ErrSendBufferFull
+		}
+	}
+}
+
+func (a *agent) dropped() {
+	atomic.AddInt64(&a.conn.Dropped, 1)
+	atomic.AddInt64(&dropped, 1)
 }
 
 func (a *agent) Push(session *session.Session, route string, v interface{}) error {
@@ -146,25 +317,54 @@ func (a *agent) Response(session *session.Session, v interface{}) error {
 	return transporter.response(session, data)
 }
 
-func (a *agent) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
-	r, err := routelib.Decode(route)
-	if err != nil {
+// Bind uid to the session, duplicate login policy will be applied
+func (a *agent) Bind(session *session.Session, uid int64) error {
+	if err := transporter.bind(session, uid); err != nil {
 		return err
 	}
+	return a.Sync(session)
+}
 
-	if app.config.Type == r.ServerType {
-		return ErrRPCLocal
+// Kick send a kick packet with reason to client, the agent will be closed
+// after the packet has been written
+func (a *agent) Kick(reason string) error {
+	data, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return err
 	}
 
-	data, err := gobEncode(args...)
+	p, err := packet.Pack(&packet.Packet{Type: packet.Kick, Data: data})
 	if err != nil {
 		return err
 	}
 
-	ret, err := cluster.Call(rpc.User, r, session, data)
-	if err != nil {
+	if err := a.Send(p); err != nil {
+		a.close(session.CloseReasonKick)
 		return err
 	}
+	a.closeAfterFlush(session.CloseReasonKick)
+	return nil
+}
+
+// closeAfterFlush close the agent with reason after all pending packets in
+// send buffer have been written
+func (a *agent) closeAfterFlush(reason session.CloseReason) {
+	a.session.SetCloseReason(reason)
+	if err := a.Send(nil); err != nil {
+		a.close(reason)
+	}
+}
+
+// Sync push session state to all backend servers the session has been
+// routed to
+func (a *agent) Sync(session *session.Session) error {
+	return cluster.SessionSync(session, "")
+}
+
+func (a *agent) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
+	return callRemote(context.Background(), session, route, reply, args...)
+}
 
-	return gobDecode(reply, ret)
+func (a *agent) CallContext(ctx context.Context, session *session.Session, route string, reply interface{}, args ...interface{}) error {
+	return callRemote(ctx, session, route, reply, args...)
 }
@@ -0,0 +1,130 @@
+package starx
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/session"
+)
+
+// fill the send buffer of a new agent, the buffer is not consumed since no
+// write loop running
+func newFullAgent(policy OverflowPolicy, timeout time.Duration) (*agent, net.Conn) {
+	c1, c2 := net.Pipe()
+	a := newAgent(c1)
+	a.setOverflowPolicy(policy, timeout)
+	for i := 0; i < packetBufferSize; i++ {
+		a.sendBuffer <- []byte{byte(i)}
+	}
+	return a, c2
+}
+
+func TestAgent_Overflow(t *testing.T) {
+	a, c := newFullAgent(OverflowDropNewest, 0)
+	defer c.Close()
+	if err := a.Send([]byte("newest")); err != ErrSendBufferFull {
+		t.Fatalf("expect buffer full, got %v", err)
+	}
+	if a.session.ConnInfo().Dropped != 1 {
+		t.Fail()
+	}
+
+	a, c = newFullAgent(OverflowDropOldest, 0)
+	defer c.Close()
+	if err := a.Send([]byte("newest")); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-a.sendBuffer; m[0] != 1 {
+		t.Fatalf("oldest message should be dropped, got %v", m)
+	}
+	if a.session.ConnInfo().Dropped != 1 {
+		t.Fail()
+	}
+
+	a, c = newFullAgent(OverflowBlock, 10*time.Millisecond)
+	defer c.Close()
+	if err := a.Send([]byte("newest")); err != ErrSendBufferFull {
+		t.Fatalf("expect buffer full, got %v", err)
+	}
+
+	a, c = newFullAgent(OverflowKick, 0)
+	defer c.Close()
+	if err := a.Send([]byte("newest")); err != ErrSendBufferFull {
+		t.Fatalf("expect buffer full, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for a.session.CloseReason() != session.CloseReasonSlowConsumer {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client should be kicked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAgent_ConcurrentClose(t *testing.T) {
+	a, c := newFullAgent(OverflowKick, 0)
+	defer c.Close()
+
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			a.close(session.CloseReasonServerClose)
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if a.getStatus() != statusClosed || a.session.CloseReason() != session.CloseReasonServerClose {
+		t.Fail()
+	}
+}
+
+func TestAgent_SendNonBlocking(t *testing.T) {
+	a, c := newFullAgent(OverflowBlock, 0)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- a.sendNonBlocking([]byte("broadcast")) }()
+	select {
+	case err := <-done:
+		if err != ErrSendBufferFull {
+			t.Fatalf("expect buffer full, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked on slow client")
+	}
+}
+
+func TestTransportService_HeartbeatFullBuffer(t *testing.T) {
+	app.config.IsFrontend = true
+	defer func() { app.config.IsFrontend = false }()
+
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowKick} {
+		a, c := newFullAgent(policy, 0)
+		a.setStatus(statusWorking)
+		transporter.Lock()
+		transporter.agents[a.id] = a
+		transporter.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			transporter.heartbeat()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("heartbeat blocked on slow client, policy=%d", policy)
+		}
+		if a.getStatus() != statusWorking || len(a.sendBuffer) != packetBufferSize {
+			t.Fatalf("slow client should be kept, policy=%d", policy)
+		}
+
+		transporter.Lock()
+		delete(transporter.agents, a.id)
+		transporter.Unlock()
+		c.Close()
+	}
+}
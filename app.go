@@ -29,6 +29,7 @@ import (
 	"syscall"
 
 	"github.com/gorilla/websocket"
+	"github.com/lonnng/starx/cluster"
 	"github.com/lonnng/starx/log"
 )
 
@@ -47,24 +48,58 @@ func startup() {
 		}
 	}()
 
-	sg := make(chan os.Signal)
-	signal.Notify(sg, syscall.SIGINT)
+	// register current server to discovery
+	joinCluster()
 
-	// stop server
-	select {
-	case <-env.die:
-		log.Infof("The app will shutdown in a few seconds")
-	case s := <-sg:
-		log.Infof("got signal: %v", s)
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, syscall.SIGINT, syscall.SIGHUP)
+
+	// stop server, servers config will be reloaded when SIGHUP received
+wait:
+	for {
+		select {
+		case <-env.die:
+			log.Infof("The app will shutdown in a few seconds")
+			break wait
+		case s := <-sg:
+			log.Infof("got signal: %v", s)
+			if s == syscall.SIGHUP {
+				reloadServers()
+				continue
+			}
+			break wait
+		}
 	}
 
 	log.Infof("server: " + app.config.Id + " is stopping...")
 
+	leaveCluster()
+
+	// close all sessions in current server
+	transporter.shutdown()
+
 	// shutdown all components registered by application, that
 	// call by reverse order against register
 	shutdownComps()
 }
 
+// register current server to discovery, and watch the topology changes
+func joinCluster() {
+	if err := env.discovery.Register(app.config); err != nil {
+		log.Errorf("register to discovery failed(%s)", err.Error())
+	}
+	if err := env.discovery.Watch(cluster.SyncServers); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// deregister current server from discovery before shutdown
+func leaveCluster() {
+	if err := env.discovery.Deregister(app.config.Id); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
 // Enable current server accept connection
 func listenAndServe() {
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", app.config.Host, app.config.Port))
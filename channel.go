@@ -3,137 +3,74 @@ package starx
 import (
 	"sync"
 
-	"github.com/lonnng/starx/log"
 	"github.com/lonnng/starx/session"
 )
 
+// Channel is a compatible wrapper of Group, all capabilities of Group can be
+// used via channel, new code should use Group directly
 type Channel struct {
-	sync.RWMutex
-	name    string                     // channel name
-	uidMap  map[int64]*session.Session // uid map to session pointer
-	members []int64                    // all user ids
+	*Group
 }
 
 func newChannel(n string) *Channel {
-	return &Channel{
-		name:   n,
-		uidMap: make(map[int64]*session.Session)}
+	return &Channel{Group: NewGroup(n)}
 }
 
-func (c *Channel) Member(uid int64) *session.Session {
-	c.RLock()
-	defer c.RUnlock()
-
-	return c.uidMap[uid]
+func (c *Channel) Add(session *session.Session) {
+	c.Group.Add(session)
 }
 
-func (c *Channel) Members() []int64 {
-	c.RLock()
-	defer c.RUnlock()
-
-	return c.members
+func (c *Channel) Leave(uid int64) {
+	c.Group.Leave(uid)
 }
 
-// Push message to partial client, which filter return true
-func (c *Channel) Multicast(route string, v interface{}, filter SessionFilter) error {
-	data, err := serializeOrRaw(v)
-	if err != nil {
-		return err
-	}
-
-	log.Debugf("Type=Multicast Route=%s, Data=%+v", route, v)
-
-	c.RLock()
-	defer c.RUnlock()
-
-	for _, s := range c.uidMap {
-		if !filter(s) {
-			continue
-		}
-		err = transporter.push(s, route, data)
-		if err != nil {
-			log.Error(err.Error())
-		}
-	}
-
-	return nil
+func (c *Channel) LeaveAll() {
+	c.Group.LeaveAll()
 }
 
-// Push message to all client
-func (c *Channel) Broadcast(route string, v interface{}) error {
-	data, err := serializeOrRaw(v)
-	if err != nil {
-		return err
-	}
-
-	log.Debugf("Type=Broadcast Route=%s, Data=%+v", route, v)
-
-	c.RLock()
-	defer c.RUnlock()
-
-	for _, s := range c.uidMap {
-		err = transporter.push(s, route, data)
-		if err != nil {
-			log.Error(err.Error())
-		}
-	}
-
-	return err
+func (c *Channel) Destroy() {
+	c.Group.Close()
 }
 
-func (c *Channel) IsContain(uid int64) bool {
-	c.RLock()
-	defer c.RUnlock()
-
-	if _, ok := c.uidMap[uid]; ok {
-		return true
-	}
+// ChannelService manages all channels created by application
+var ChannelService = &channelService{channels: make(map[string]*Channel)}
 
-	return false
+type channelService struct {
+	sync.RWMutex
+	channels map[string]*Channel
 }
 
-func (c *Channel) Add(session *session.Session) {
-	c.Lock()
-	defer c.Unlock()
-
-	c.uidMap[session.Uid] = session
-	c.members = append(c.members, session.Uid)
-}
+// NewChannel create a channel with the name, the existed channel will be
+// returned if the name has been used
+func (cs *channelService) NewChannel(name string) *Channel {
+	cs.Lock()
+	defer cs.Unlock()
 
-func (c *Channel) Leave(uid int64) {
-	if !c.IsContain(uid) {
-		return
+	if c, ok := cs.channels[name]; ok && !c.isClosed() {
+		return c
 	}
 
-	c.Lock()
-	defer c.Unlock()
-
-	var temp []int64
-	for i, u := range c.members {
-		if u == uid {
-			temp = append(temp, c.members[:i]...)
-			c.members = append(temp, c.members[(i+1):]...)
-			break
-		}
-	}
-	delete(c.uidMap, uid)
+	c := newChannel(name)
+	cs.channels[name] = c
+	return c
 }
 
-func (c *Channel) LeaveAll() {
-	c.Lock()
-	defer c.Unlock()
+// Channel returns the channel with the name, nil will be returned if not exists
+func (cs *channelService) Channel(name string) *Channel {
+	cs.RLock()
+	defer cs.RUnlock()
 
-	c.uidMap = make(map[int64]*session.Session)
-	c.members = make([]int64, 0)
+	return cs.channels[name]
 }
 
-func (c *Channel) Count() int {
-	c.RLock()
-	defer c.RUnlock()
+// DestroyChannel destroy the channel with the name
+func (cs *channelService) DestroyChannel(name string) {
+	cs.Lock()
+	c, ok := cs.channels[name]
+	delete(cs.channels, name)
+	cs.Unlock()
 
-	return len(c.uidMap)
-}
-
-func (c *Channel) Destroy() {
-	c.LeaveAll()
+	if ok {
+		c.Destroy()
+	}
 }
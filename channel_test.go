@@ -14,7 +14,7 @@ func TestChannel_Add(t *testing.T) {
 	w := make(chan bool, paraCount)
 	for i := 0; i < paraCount; i++ {
 		go func(id int) {
-			s := &session.Session{}
+			s := session.New(nil)
 			s.Bind(int64(id + 1))
 			c.Add(s)
 			w <- true
@@ -29,7 +29,7 @@ func TestChannel_Add(t *testing.T) {
 		t.Fail()
 	}
 
-	n := rand.Int63n(int64(paraCount) + 1)
+	n := rand.Int63n(int64(paraCount)) + 1
 	if !c.IsContain(n) {
 		t.Fail()
 	}
@@ -0,0 +1,206 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lonnng/starx/session"
+)
+
+// Balancer represents the strategy of selecting a backend server for the
+// session, which is used when the session has not been routed to any server
+// of the type
+type Balancer interface {
+	// Select returns id of the selected server, servers is not empty, s is
+	// nil when the call does not belong to any session
+	Select(servers []*ServerConfig, s *session.Session) string
+}
+
+// loads reported by backend servers
+var loads = &struct {
+	sync.RWMutex
+	servers map[string]int64
+}{servers: make(map[string]int64)}
+
+// SetLoad set the load of server, which is used by LeastLoaded balancer
+func SetLoad(svrId string, load int64) {
+	loads.Lock()
+	defer loads.Unlock()
+
+	loads.servers[svrId] = load
+}
+
+// Load returns the latest load of server
+func Load(svrId string) int64 {
+	loads.RLock()
+	defer loads.RUnlock()
+
+	return loads.servers[svrId]
+}
+
+func removeLoad(svrId string) {
+	loads.Lock()
+	defer loads.Unlock()
+
+	delete(loads.servers, svrId)
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+// RoundRobin returns a balancer which selects servers in turn
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Select(servers []*ServerConfig, s *session.Session) string {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return servers[n%uint64(len(servers))].Id
+}
+
+type weightedRandom struct{}
+
+// WeightedRandom returns a balancer which selects a random server with the
+// probability proportional to ServerConfig.Weight, weight not greater than
+// zero is treated as 1
+func WeightedRandom() Balancer {
+	return weightedRandom{}
+}
+
+func weight(svr *ServerConfig) int {
+	if svr.Weight <= 0 {
+		return 1
+	}
+	return svr.Weight
+}
+
+func (weightedRandom) Select(servers []*ServerConfig, s *session.Session) string {
+	total := 0
+	for _, svr := range servers {
+		total += weight(svr)
+	}
+
+	r := rand.Intn(total)
+	for _, svr := range servers {
+		if r -= weight(svr); r < 0 {
+			return svr.Id
+		}
+	}
+	return servers[len(servers)-1].Id
+}
+
+type leastLoaded struct {
+	sync.Mutex
+}
+
+// LeastLoaded returns a balancer which selects the server with the least load
+// reported by backend servers, load of the selected server is increased until
+// the next report, which avoids all sessions routed to the same server
+// between two reports
+func LeastLoaded() Balancer {
+	return &leastLoaded{}
+}
+
+func (b *leastLoaded) Select(servers []*ServerConfig, s *session.Session) string {
+	b.Lock()
+	defer b.Unlock()
+
+	selected := servers[0].Id
+	min := Load(selected)
+	for _, svr := range servers[1:] {
+		if load := Load(svr.Id); load < min {
+			selected, min = svr.Id, load
+		}
+	}
+	SetLoad(selected, min+1)
+	return selected
+}
+
+type consistentHash struct {
+	key      string
+	replicas int
+
+	sync.Mutex
+	ids   string   // ids of servers which the ring built from
+	ring  []uint32 // sorted hashes of virtual nodes
+	nodes map[uint32]string
+}
+
+// ConsistentHash returns a balancer which selects server by consistent
+// hashing on the session value of key, or uid when key is empty, session id
+// is used when the session has not bound uid. Every server is mapped to
+// replicas virtual nodes in the ring, so only sessions on the changed server
+// are remapped when servers join or leave
+func ConsistentHash(key string, replicas int) Balancer {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &consistentHash{key: key, replicas: replicas}
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (b *consistentHash) build(servers []*ServerConfig) {
+	ids := make([]string, len(servers))
+	for i, svr := range servers {
+		ids[i] = svr.Id
+	}
+	sort.Strings(ids)
+
+	key := strings.Join(ids, ",")
+	if key == b.ids {
+		return
+	}
+
+	b.ids = key
+	b.ring = b.ring[:0]
+	b.nodes = make(map[uint32]string)
+	for _, id := range ids {
+		for i := 0; i < b.replicas; i++ {
+			h := hash(id + "#" + strconv.Itoa(i))
+			b.ring = append(b.ring, h)
+			b.nodes[h] = id
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+}
+
+func (b *consistentHash) sessionKey(s *session.Session) string {
+	if b.key != "" {
+		return fmt.Sprint(s.Value(b.key))
+	}
+	if s.Uid > 0 {
+		return strconv.FormatInt(s.Uid, 10)
+	}
+	return strconv.FormatInt(s.ID, 10)
+}
+
+func (b *consistentHash) Select(servers []*ServerConfig, s *session.Session) string {
+	// nothing to hash on
+	if s == nil {
+		return servers[rand.Intn(len(servers))].Id
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.build(servers)
+
+	h := hash(b.sessionKey(s))
+	i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= h })
+	if i == len(b.ring) {
+		i = 0
+	}
+	return b.nodes[b.ring[i]]
+}
@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lonnng/starx/session"
+)
+
+func testServers(n int) []*ServerConfig {
+	servers := make([]*ServerConfig, n)
+	for i := range servers {
+		servers[i] = &ServerConfig{Type: "chat", Id: fmt.Sprintf("chat-%d", i+1)}
+	}
+	return servers
+}
+
+func TestRoundRobin(t *testing.T) {
+	servers := testServers(3)
+	b := RoundRobin()
+
+	for i := 0; i < 9; i++ {
+		if id := b.Select(servers, session.New(nil)); id != servers[i%3].Id {
+			t.Fatalf("expect %s, got %s", servers[i%3].Id, id)
+		}
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	servers := testServers(2)
+	servers[0].Weight = 9
+
+	b := WeightedRandom()
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[b.Select(servers, session.New(nil))]++
+	}
+
+	// expect 9000 vs 1000
+	if counts["chat-1"] < 8500 || counts["chat-2"] < 500 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	servers := testServers(3)
+	SetLoad("chat-1", 10)
+	SetLoad("chat-2", 3)
+	SetLoad("chat-3", 5)
+	defer func() {
+		for _, svr := range servers {
+			removeLoad(svr.Id)
+		}
+	}()
+
+	b := LeastLoaded()
+	var selected []string
+	for i := 0; i < 4; i++ {
+		selected = append(selected, b.Select(servers, session.New(nil)))
+	}
+
+	// load of selected server increased until next report
+	expect := []string{"chat-2", "chat-2", "chat-2", "chat-3"}
+	for i := range expect {
+		if selected[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, selected)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	servers := testServers(4)
+	b := ConsistentHash("", 100)
+
+	sessions := make([]*session.Session, 1000)
+	before := make([]string, len(sessions))
+	counts := make(map[string]int)
+	for i := range sessions {
+		sessions[i] = session.New(nil)
+		sessions[i].Uid = int64(i + 1)
+		before[i] = b.Select(servers, sessions[i])
+		counts[before[i]]++
+	}
+
+	for _, svr := range servers {
+		if counts[svr.Id] == 0 {
+			t.Fatalf("no session selected %s: %v", svr.Id, counts)
+		}
+	}
+
+	// same uid always selects the same server
+	for i, s := range sessions {
+		if id := b.Select(servers, s); id != before[i] {
+			t.Fatalf("uid %d selected %s and %s", s.Uid, before[i], id)
+		}
+	}
+
+	// only sessions on the left server are remapped
+	for i, s := range sessions {
+		id := b.Select(servers[:3], s)
+		if before[i] != "chat-4" && id != before[i] {
+			t.Fatalf("uid %d remapped from %s to %s", s.Uid, before[i], id)
+		}
+		if id == "chat-4" {
+			t.Fatalf("uid %d selected left server", s.Uid)
+		}
+	}
+}
+
+func TestConsistentHash_SessionKey(t *testing.T) {
+	servers := testServers(4)
+	b := ConsistentHash("room", 0)
+
+	s1 := session.New(nil)
+	s1.Set("room", "lobby")
+	s2 := session.New(nil)
+	s2.Set("room", "lobby")
+
+	if b.Select(servers, s1) != b.Select(servers, s2) {
+		t.Fatal("sessions with the same key selected different servers")
+	}
+}
+
+func TestSelectServer(t *testing.T) {
+	servers := testServers(3)
+	defer func() {
+		delete(router, "balance")
+		delete(balancers, "balance")
+	}()
+
+	SetBalancer("balance", RoundRobin())
+	if id := selectServer("balance", servers, session.New(nil)); id != "chat-1" {
+		t.Fatalf("expect chat-1, got %s", id)
+	}
+
+	// router takes precedence over balancer
+	Router("balance", func(*session.Session) string { return "chat-3" })
+	if id := selectServer("balance", servers, session.New(nil)); id != "chat-3" {
+		t.Fatalf("expect chat-3, got %s", id)
+	}
+}
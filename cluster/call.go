@@ -1,7 +1,9 @@
 package cluster
 
 import (
-	"errors"
+	"context"
+	"sync"
+	"time"
 
 	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/log"
@@ -10,33 +12,200 @@ import (
 )
 
 var (
-	sessionClosedRoute = &route.Route{Service: "__Session", Method: "Closed"}
-	sessionSyncRoute   = &route.Route{Service: "__Session", Method: "Sync"}
+	sessionClosedRoute  = &route.Route{Service: "__Session", Method: "Closed"}
+	sessionSyncRoute    = &route.Route{Service: "__Session", Method: "Sync"}
+	serverIdentifyRoute = &route.Route{Service: "__Server", Method: "Identify"}
 )
 
+// call timeouts, calls without deadline will be canceled after the timeout
+// of the server type, or the default timeout
+var timeouts = &struct {
+	sync.RWMutex
+	def     time.Duration
+	svrType map[string]time.Duration
+}{def: 5 * time.Second, svrType: make(map[string]time.Duration)}
+
+// SetCallTimeout set the default timeout of calls to servers of the type,
+// empty type represents the default timeout of all types, zero represents
+// waiting for reply forever
+func SetCallTimeout(svrType string, d time.Duration) {
+	timeouts.Lock()
+	defer timeouts.Unlock()
+
+	if svrType == "" {
+		timeouts.def = d
+	} else {
+		timeouts.svrType[svrType] = d
+	}
+}
+
+// CallTimeout returns the default timeout of calls to servers of the type
+func CallTimeout(svrType string) time.Duration {
+	timeouts.RLock()
+	defer timeouts.RUnlock()
+
+	if d, ok := timeouts.svrType[svrType]; ok {
+		return d
+	}
+	return timeouts.def
+}
+
 // Client send request
 // First argument is namespace, can be set `user` or `sys`
 func Call(rpcKind rpc.RpcKind, route *route.Route, session *session.Session, args []byte) ([]byte, error) {
-	client, err := ClientByType(route.ServerType, session)
+	return CallContext(context.Background(), rpcKind, route, session, args)
+}
+
+// CallContext send request, and wait for reply until context done, the
+// default timeout of server type is used when context has no deadline,
+// rpc.ErrTimeout returned when deadline exceeded. Nil session represents
+// the request does not belong to any session, the server is selected by the
+// balancer of the type for every request
+func CallContext(ctx context.Context, rpcKind rpc.RpcKind, route *route.Route, session *session.Session, args []byte) ([]byte, error) {
+	client, p, err := clientByType(route.ServerType, session)
 	if err != nil {
 		log.Infof(err.Error())
 		return nil, err
 	}
-	reply := new([]byte)
-	err = client.Call(rpcKind, route.Service, route.Method, session.Entity.ID(), reply, args)
+
+	if session == nil {
+		return call(ctx, client, rpcKind, route, 0, args)
+	}
+
+	// push session state to the server before the first request of the
+	// session on the connections, so backend session contains uid and
+	// synchronized data, even if the connections have been re-established
+	sid := session.Entity.ID()
+	if !p.isSynced(sid) {
+		if err := syncSession(client, session); err != nil {
+			log.Errorf(err.Error())
+		} else {
+			p.setSynced(sid)
+		}
+	}
+
+	return call(ctx, client, rpcKind, route, sid, args)
+}
+
+// CallServer send request to the server specified by id, the request does not
+// belong to any session
+func CallServer(ctx context.Context, rpcKind rpc.RpcKind, svrId string, route *route.Route, args []byte) ([]byte, error) {
+	svr, err := Server(svrId)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+	if svr.Type != route.ServerType {
+		return nil, ErrServerTypeInvalid
+	}
+
+	client, err := Client(svrId)
+	if err != nil {
+		return nil, err
+	}
+	return call(ctx, client, rpcKind, route, 0, args)
+}
+
+// Result represents the reply or error of a server
+type Result struct {
+	Data []byte
+	Err  error
+}
+
+// CallAll send request to all servers of the route type concurrently without
+// session, and wait for all replies, every server has its own timeout, the
+// default timeout of the type is used when timeout is zero, results are keyed
+// by server id
+func CallAll(ctx context.Context, rpcKind rpc.RpcKind, route *route.Route, args []byte, timeout time.Duration) map[string]*Result {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		servers = serversByType(route.ServerType)
+		results = make(map[string]*Result, len(servers))
+	)
+
+	for _, svr := range servers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			ctx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			data, err := CallServer(ctx, rpcKind, id, route, args)
+
+			mu.Lock()
+			results[id] = &Result{Data: data, Err: err}
+			mu.Unlock()
+		}(svr.Id)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// zero sid represents the request does not belong to any session
+func call(ctx context.Context, client *rpc.Client, rpcKind rpc.RpcKind, route *route.Route, sid int64, args []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		if d := CallTimeout(route.ServerType); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+	}
+
+	reply := new([]byte)
+	if err := client.CallContext(ctx, rpcKind, route.Service, route.Method, sid, reply, args); err != nil {
+		return nil, err
 	}
 	return *reply, nil
 }
 
 func SessionClosed(session *session.Session) {
-	for _, t := range svrTypes {
-		client, err := ClientByType(t, session)
+	sid := session.Entity.ID()
+	for _, id := range session.ServerIDs() {
+		client, err := SessionClient(id, sid)
+		if err != nil {
+			continue
+		}
+
+		reason := []byte{byte(session.CloseReason())}
+		client.Call(rpc.Sys, sessionClosedRoute.Service, sessionClosedRoute.Method, sid, nil, reason)
+	}
+
+	// session may be pinned by servers which it has not been routed to, e.g.
+	// uid binding notifications
+	releaseSession(sid)
+}
+
+// SessionSync push the session state to all backend servers which the session
+// has been routed to, the server specified by except will be skipped
+func SessionSync(session *session.Session, except string) error {
+	for _, id := range session.ServerIDs() {
+		if id == except {
+			continue
+		}
+
+		client, err := SessionClient(id, session.Entity.ID())
 		if err != nil {
+			log.Errorf(err.Error())
 			continue
 		}
 
-		client.Call(rpc.Sys, sessionClosedRoute.Service, sessionClosedRoute.Method, session.Entity.ID(), nil, nil)
+		if err := syncSession(client, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncSession(client *rpc.Client, session *session.Session) error {
+	data, err := session.SyncData()
+	if err != nil {
+		return err
 	}
+	return client.Call(rpc.Sys, sessionSyncRoute.Service, sessionSyncRoute.Method, session.Entity.ID(), nil, data)
 }
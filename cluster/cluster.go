@@ -1,10 +1,12 @@
 package cluster
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/log"
@@ -17,25 +19,34 @@ var (
 	svrTypeMaps map[string][]string      // all servers type maps
 	svrIdMaps   map[string]*ServerConfig // all servers id maps
 
-	mutex        sync.RWMutex           // protect ClientIdMaps
-	clientIdMaps map[string]*rpc.Client // all rpc clients
-	appConfig    *ServerConfig          // current app config
+	mutex        sync.RWMutex     // protect ClientIdMaps
+	clientIdMaps map[string]*pool // rpc connections of all servers
+	appConfig    *ServerConfig    // current app config
 
 	sessionManager SessionManager //get session instance
 )
 
 var (
-	ErrServerNotFound = errors.New("server config not found")
+	ErrServerNotFound    = errors.New("server config not found")
+	ErrServerUnavailable = errors.New("server unavailable")
+	ErrServerTypeInvalid = errors.New("server type does not match the route")
 )
 
 type SessionManager interface {
 	Session(sid int64) (*session.Session, error)
+	SyncSession(s *session.Session, data []byte, from string) error
+	Kick(s *session.Session, reason string) error
+	Multicast(sids []int64, route string, data []byte)
+	// BackendConnected is invoked after rpc connections to the backend server
+	// established, include reconnected, backend state bound to the previous
+	// connections has been lost
+	BackendConnected(svrId string)
 }
 
 func init() {
 	svrTypeMaps = make(map[string][]string)
 	svrIdMaps = make(map[string]*ServerConfig)
-	clientIdMaps = make(map[string]*rpc.Client)
+	clientIdMaps = make(map[string]*pool)
 }
 
 func DumpSvrIdMaps() {
@@ -128,9 +139,26 @@ func RemoveServer(svrId string) {
 
 	// remove from ServerIdMaps
 	delete(svrIdMaps, svrId)
+	removeLoad(svrId)
+	setHealth(svrId, HealthUp)
 	CloseClient(svrId)
 }
 
+// Backends retrieve ids of all backend servers except current server
+func Backends() []string {
+	svrLock.RLock()
+	defer svrLock.RUnlock()
+
+	var ids []string
+	for id, svr := range svrIdMaps {
+		if svr.IsFrontend || id == appConfig.Id {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func Server(id string) (*ServerConfig, error) {
 	svrLock.RLock()
 	defer svrLock.RUnlock()
@@ -156,51 +184,131 @@ func UpdateServer(newSvr *ServerConfig) {
 	svrIdMaps[svr.Id] = newSvr
 }
 
+// SyncServers replace the servers with the latest cluster topology, e.g.
+// membership sent by master server or servers.json reloaded, servers not
+// contained in the topology will be removed except current server, rpc
+// client of server whose address changed will be reconnected
+func SyncServers(servers []*ServerConfig) {
+	latest := make(map[string]bool)
+	for _, svr := range servers {
+		latest[svr.Id] = true
+		if svr.Id == appConfig.Id {
+			continue
+		}
+
+		old, err := Server(svr.Id)
+		switch {
+		case err != nil:
+			log.Infof("server joined cluster(%s)", svr.String())
+			Register(svr)
+			// frontend server connects to the new backend server eagerly, so
+			// that the backend server knows the state of frontend server
+			if appConfig.IsFrontend && !svr.IsFrontend {
+				go connect(svr.Id)
+			}
+		case *old == *svr:
+			// unchanged
+		case old.Type != svr.Type:
+			log.Infof("server changed(%s => %s)", old.String(), svr.String())
+			RemoveServer(svr.Id)
+			Register(svr)
+		default:
+			log.Infof("server changed(%s => %s)", old.String(), svr.String())
+			UpdateServer(svr)
+			// reconnect to the new address when next call
+			if old.Host != svr.Host || old.Port != svr.Port {
+				CloseClient(svr.Id)
+			}
+		}
+	}
+
+	svrLock.RLock()
+	var removed []string
+	for id := range svrIdMaps {
+		if !latest[id] && id != appConfig.Id {
+			removed = append(removed, id)
+		}
+	}
+	svrLock.RUnlock()
+
+	for _, id := range removed {
+		log.Infof("server left cluster(Id: %s)", id)
+		RemoveServer(id)
+	}
+}
+
 func CloseClient(svrId string) {
 	mutex.Lock()
-	defer mutex.Unlock()
+	p, ok := clientIdMaps[svrId]
+	delete(clientIdMaps, svrId)
+	mutex.Unlock()
 
-	client, ok := clientIdMaps[svrId]
 	if !ok {
 		log.Infof("%s not found in rpc client list", svrId)
 		return
 	}
 
-	delete(clientIdMaps, svrId)
-	client.Close()
+	p.close()
 
 	log.Infof("%s rpc client has been removed.", svrId)
 	DumpClientIdMaps()
 }
 
+// ClientByType returns rpc client of the server of the type which the session
+// routed to, session-less requests select a server for every request
 func ClientByType(svrType string, session *session.Session) (*rpc.Client, error) {
+	client, _, err := clientByType(svrType, session)
+	return client, err
+}
+
+// returns the connection and the connection pool of the server, see
+// sessionClient
+func clientByType(svrType string, session *session.Session) (*rpc.Client, *pool, error) {
 	if svrType == appConfig.Type {
-		return nil, errors.New(fmt.Sprintf("current server has the same type(Type: %s)", svrType))
+		return nil, nil, errors.New(fmt.Sprintf("current server has the same type(Type: %s)", svrType))
 	}
 
-	// fast mode
-	if id := session.ServerID(svrType); id != "" {
-		return Client(id)
+	// session-less request, server is selected for every request
+	if session == nil {
+		if servers := healthyServers(serversByType(svrType)); len(servers) > 0 {
+			return sessionClient(selectServer(svrType, servers, nil), 0)
+		}
+		return nil, nil, errors.New("not found rpc client")
 	}
 
-	// slow mode
-	svrIds := svrTypeMaps[svrType]
-	if n := len(svrIds); n > 0 {
-		var id string
-		if fn := router[svrType]; fn != nil {
-			// try to get user-define router function
-			id = fn(session)
-		} else {
-			// select a random server when could not found user-define router
-			r := rand.Intn(n)
-			id = svrIds[r]
-		}
+	// requests of the session are pinned to the same connection
+	var sid int64
+	if session.Entity != nil {
+		sid = session.Entity.ID()
+	}
+
+	// fast mode, session is routed to another server when the server it has
+	// been routed to is down or removed
+	if id := session.ServerID(svrType); id != "" && routable(id) {
+		return sessionClient(id, sid)
+	}
 
+	// slow mode, unhealthy servers are skipped
+	if servers := healthyServers(serversByType(svrType)); len(servers) > 0 {
+		id := selectServer(svrType, servers, session)
 		session.SetServerID(svrType, id)
-		return Client(id)
+		return sessionClient(id, sid)
 	}
 
-	return nil, errors.New("not found rpc client")
+	return nil, nil, errors.New("not found rpc client")
+}
+
+func serversByType(svrType string) []*ServerConfig {
+	svrLock.RLock()
+	defer svrLock.RUnlock()
+
+	var servers []*ServerConfig
+	for _, id := range svrTypeMaps[svrType] {
+		if svr, ok := svrIdMaps[id]; ok {
+			servers = append(servers, svr)
+		}
+	}
+	return servers
 }
 
 // Get RPC client by server id(`connector-server-1`), and return the client if
@@ -208,66 +316,186 @@ func ClientByType(svrType string, session *session.Session) (*rpc.Client, error)
 // remote server when remote server network connections have not made by now,
 // and return a nil value when server id not found or target machine refuse it.
 func Client(svrId string) (*rpc.Client, error) {
+	return SessionClient(svrId, 0)
+}
+
+// SessionClient returns the RPC client of server for the session, requests
+// of the same session are sent through the same connection when there are
+// more than one connection to the server
+func SessionClient(svrId string, sid int64) (*rpc.Client, error) {
+	client, _, err := sessionClient(svrId, sid)
+	return client, err
+}
+
+// sessionClient returns the connection of the session and the connection pool
+// of the server, which records whether the session state has been pushed
+func sessionClient(svrId string, sid int64) (*rpc.Client, *pool, error) {
 	mutex.RLock()
-	client, ok := clientIdMaps[svrId]
+	p, ok := clientIdMaps[svrId]
 	mutex.RUnlock()
 
-	if ok && client != nil {
-		return client, nil
+	if ok && p != nil {
+		return p.client(sid), p, nil
 	}
 
+	svrLock.RLock()
 	svr, ok := svrIdMaps[svrId]
+	svrLock.RUnlock()
 	if !ok || svr == nil {
-		return nil, errors.New(fmt.Sprintf("server id does not exists(Id: %s)", svrId))
+		return nil, nil, errors.New(fmt.Sprintf("server id does not exists(Id: %s)", svrId))
 
 	}
 
 	// current server
 	if svr.Id == appConfig.Id {
-		return nil, errors.New(svr.Id + " is current server")
+		return nil, nil, errors.New(svr.Id + " is current server")
 	}
 
 	// frontend server
 	if svr.IsFrontend {
-		return nil, errors.New(svr.Id + " is frontend server, can handle rpc request")
+		return nil, nil, errors.New(svr.Id + " is frontend server, can handle rpc request")
+	}
+
+	// server is reconnecting
+	if ServerHealth(svr.Id) != HealthUp {
+		return nil, nil, ErrServerUnavailable
 	}
 
-	client, err := rpc.Dial("tcp4", fmt.Sprintf("%s:%d", svr.Host, svr.Port))
+	p, err := dial(svr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return p.client(sid), p, nil
+}
+
+func connect(svrId string) {
+	if _, err := Client(svrId); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// release the connections pinned by the closed session
+func releaseSession(sid int64) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	for _, p := range clientIdMaps {
+		p.release(sid)
+	}
+}
+
+// dial the server and add the connections to client list, server will be
+// reconnected when any connection broken
+func dial(svr *ServerConfig) (*pool, error) {
+	var clients []*rpc.Client
+	for i := int32(0); i < atomic.LoadInt32(&poolSize); i++ {
+		client, err := rpc.Dial("tcp4", fmt.Sprintf("%s:%d", svr.Host, svr.Port))
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		// report current server id, so that the remote server can recognize
+		// connections from the same server
+		if appConfig != nil {
+			r := serverIdentifyRoute
+			client.Call(rpc.Sys, r.Service, r.Method, 0, nil, []byte(appConfig.Id))
+		}
+		clients = append(clients, client)
 	}
 	log.Infof("%s establish rpc client successful.", svr.Id)
 
-	// on client shutdown
-	client.OnShutdown(func() {
-		RemoveServer(svr.Id)
-	})
+	p := newPool(clients)
 
 	mutex.Lock()
-	clientIdMaps[svr.Id] = client
+	// connected by another goroutine
+	if exist, ok := clientIdMaps[svr.Id]; ok {
+		mutex.Unlock()
+		p.close()
+		return exist, nil
+	}
+	clientIdMaps[svr.Id] = p
 	mutex.Unlock()
 
-	// handle sys rpc push/response
-	go func() {
-		for resp := range client.ResponseChan {
-			s, err := sessionManager.Session(resp.Sid)
-			if err != nil {
+	for _, client := range clients {
+		client := client
+
+		// on client shutdown, connections closed by CloseClient have been
+		// removed from client list, otherwise the connection is broken
+		client.OnShutdown(func() {
+			mutex.Lock()
+			broken := clientIdMaps[svr.Id] == p
+			if broken {
+				delete(clientIdMaps, svr.Id)
+			}
+			mutex.Unlock()
+
+			if broken {
+				p.close()
+				go reconnect(svr.Id)
+			}
+		})
+
+		go handleResponses(svr, client)
+	}
+
+	if sessionManager != nil {
+		sessionManager.BackendConnected(svr.Id)
+	}
+
+	return p, nil
+}
+
+// handle sys rpc push/response
+func handleResponses(svr *ServerConfig, client *rpc.Client) {
+	for resp := range client.ResponseChan {
+		// cluster membership changed, pushed by master server
+		if resp.Kind == rpc.ClusterUpdate {
+			var servers []*ServerConfig
+			if err := json.Unmarshal(resp.Data, &servers); err != nil {
 				log.Errorf(err.Error())
 				continue
 			}
+			SyncServers(servers)
+			continue
+		}
 
-			switch resp.Kind {
-			case rpc.HandlerPush:
-				s.Push(resp.Route, resp.Data)
-			case rpc.HandlerResponse:
-				s.Response(resp.Data)
-			default:
-				log.Errorf("invalid response kind")
+		// load reported by backend server periodically
+		if resp.Kind == rpc.LoadReport {
+			if load, err := strconv.ParseInt(string(resp.Data), 10, 64); err == nil {
+				SetLoad(svr.Id, load)
 			}
+			continue
+		}
+
+		// group broadcast from backend server, sent once with all members
+		if resp.Kind == rpc.RemotePush && len(resp.Sids) > 0 {
+			sessionManager.Multicast(resp.Sids, resp.Route, resp.Data)
+			continue
+		}
+
+		s, err := sessionManager.Session(resp.Sid)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
 		}
-	}()
 
-	return client, nil
+		switch resp.Kind {
+		case rpc.HandlerPush, rpc.RemotePush:
+			s.Push(resp.Route, resp.Data)
+		case rpc.HandlerResponse:
+			s.Response(resp.Data)
+		case rpc.SessionSync:
+			if err := sessionManager.SyncSession(s, resp.Data, svr.Id); err != nil {
+				log.Errorf(err.Error())
+			}
+		case rpc.RemoteKick:
+			sessionManager.Kick(s, string(resp.Data))
+		default:
+			log.Errorf("invalid response kind")
+		}
+	}
 }
 
 // Dump all clients that has established netword connection with remote server
@@ -282,13 +510,13 @@ func DumpClientIdMaps() {
 
 func Close() {
 	mutex.Lock()
-	mutex.Unlock()
+	defer mutex.Unlock()
 
 	// close all RPC clients
 	log.Infof("close all of socket connections")
-	for id, client := range clientIdMaps {
+	for id, p := range clientIdMaps {
 		delete(clientIdMaps, id)
-		client.Close()
+		p.close()
 	}
 }
 
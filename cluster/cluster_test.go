@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/cluster/rpc"
+	"github.com/lonnng/starx/route"
+)
+
+func TestSyncServers(t *testing.T) {
+	SetAppConfig(&ServerConfig{Type: "gate", Id: "gate-1", Port: 3250, IsFrontend: true})
+	defer SetAppConfig(nil)
+
+	Register(appConfig)
+	Register(&ServerConfig{Type: "chat", Id: "chat-1", Host: "127.0.0.1", Port: 3260})
+	Register(&ServerConfig{Type: "chat", Id: "chat-2", Host: "127.0.0.1", Port: 3261})
+	defer func() {
+		for _, id := range []string{"gate-1", "chat-1", "chat-3"} {
+			RemoveServer(id)
+		}
+	}()
+
+	// chat-1 address changed, chat-2 removed and chat-3 added, current
+	// server should be kept though not contained
+	SyncServers([]*ServerConfig{
+		{Type: "chat", Id: "chat-1", Host: "127.0.0.2", Port: 3260},
+		{Type: "chat", Id: "chat-3", Host: "127.0.0.1", Port: 3262},
+	})
+
+	if _, err := Server("gate-1"); err != nil {
+		t.Fatal(err)
+	}
+	if svr, err := Server("chat-1"); err != nil || svr.Host != "127.0.0.2" {
+		t.Fatalf("chat-1 not updated: %v, %v", svr, err)
+	}
+	if _, err := Server("chat-2"); err != ErrServerNotFound {
+		t.Fatalf("chat-2 not removed: %v", err)
+	}
+	if _, err := Server("chat-3"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := svrTypeMaps["chat"]; len(ids) != 2 {
+		t.Fatalf("unexpected chat servers: %v", ids)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	def := CallTimeout("")
+	defer SetCallTimeout("", def)
+
+	SetCallTimeout("", 3*time.Second)
+	SetCallTimeout("slow", time.Minute)
+	defer delete(timeouts.svrType, "slow")
+
+	if d := CallTimeout("chat"); d != 3*time.Second {
+		t.Fatalf("expect default timeout, got %v", d)
+	}
+	if d := CallTimeout("slow"); d != time.Minute {
+		t.Fatalf("expect 1m, got %v", d)
+	}
+}
+
+// serve replies the server id and session id of requests
+func serve(t *testing.T, id string) int {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					req, err := rpc.ReadRequest(conn)
+					if err != nil {
+						return
+					}
+					resp := &rpc.Response{
+						Kind: rpc.RemoteResponse,
+						Seq:  req.Seq,
+						Data: []byte(fmt.Sprintf("%s:%d", id, req.Sid)),
+					}
+					rpc.WriteResponse(conn, resp)
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestCallSessionless(t *testing.T) {
+	SetAppConfig(&ServerConfig{Type: "job", Id: "job-1"})
+	defer SetAppConfig(nil)
+
+	for _, id := range []string{"echo-1", "echo-2"} {
+		Register(&ServerConfig{Type: "echo", Id: id, Host: "127.0.0.1", Port: serve(t, id)})
+		defer RemoveServer(id)
+	}
+	SetBalancer("echo", RoundRobin())
+	defer delete(balancers, "echo")
+
+	r := &route.Route{ServerType: "echo", Service: "Echo", Method: "Who"}
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		data, err := CallContext(context.Background(), rpc.User, r, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(data)] = true
+	}
+	if !seen["echo-1:0"] || !seen["echo-2:0"] {
+		t.Fatalf("unexpected replies: %v", seen)
+	}
+
+	data, err := CallServer(context.Background(), rpc.User, "echo-2", r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "echo-2:0" {
+		t.Fatalf("unexpected reply: %s", data)
+	}
+
+	if _, err := CallServer(context.Background(), rpc.User, "echo-3", r, nil); err != ErrServerNotFound {
+		t.Fatalf("expect ErrServerNotFound, got %v", err)
+	}
+	r.ServerType = "chat"
+	if _, err := CallServer(context.Background(), rpc.User, "echo-1", r, nil); err != ErrServerTypeInvalid {
+		t.Fatalf("expect ErrServerTypeInvalid, got %v", err)
+	}
+}
+
+func TestCallAll(t *testing.T) {
+	SetAppConfig(&ServerConfig{Type: "job", Id: "job-1"})
+	defer SetAppConfig(nil)
+
+	for _, id := range []string{"echo-1", "echo-2"} {
+		Register(&ServerConfig{Type: "echo", Id: id, Host: "127.0.0.1", Port: serve(t, id)})
+		defer RemoveServer(id)
+	}
+
+	// server never replies
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					if _, err := rpc.ReadRequest(conn); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	Register(&ServerConfig{Type: "echo", Id: "echo-3", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port})
+	defer RemoveServer("echo-3")
+
+	r := &route.Route{ServerType: "echo", Service: "Echo", Method: "Who"}
+	start := time.Now()
+	results := CallAll(context.Background(), rpc.User, r, nil, 50*time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("calls are not concurrent: %v", d)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expect 3 results, got %d", len(results))
+	}
+	for _, id := range []string{"echo-1", "echo-2"} {
+		if ret := results[id]; ret.Err != nil || string(ret.Data) != id+":0" {
+			t.Fatalf("unexpected result of %s: %s, %v", id, ret.Data, ret.Err)
+		}
+	}
+	if ret := results["echo-3"]; ret.Err != rpc.ErrTimeout {
+		t.Fatalf("expect rpc.ErrTimeout, got %v", ret.Err)
+	}
+}
@@ -10,6 +10,7 @@ type ServerConfig struct {
 	IsFrontend  bool   `json:"is_frontend"`
 	IsMaster    bool   `json:"is_master"`
 	IsWebsocket bool   `json:"is_websocket"`
+	Weight      int    `json:"weight"` // weight used by WeightedRandom balancer, 1 by default
 }
 
 func (c *ServerConfig) String() string {
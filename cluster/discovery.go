@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lonnng/starx/log"
+)
+
+// Discovery represents the source of cluster topology, servers are listed
+// from it on startup, current server registers itself to it after started and
+// deregisters before shutdown
+type Discovery interface {
+	// List returns all servers in cluster
+	List() ([]*ServerConfig, error)
+
+	// Watch register the callback which will be called with all servers in
+	// cluster when topology changed
+	Watch(fn func([]*ServerConfig)) error
+
+	// Register add the server to cluster
+	Register(server *ServerConfig) error
+
+	// Deregister remove the server from cluster
+	Deregister(id string) error
+}
+
+// sort servers by id, which makes the list comparable
+func sortServers(servers []*ServerConfig) {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Id < servers[j].Id })
+}
+
+func equalServers(a, b []*ServerConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FileDiscovery lists servers from the servers.json, which contains servers
+// grouped by server type, the file is polled when watching, watchers will be
+// notified when the file modified or reloaded. Servers are managed by the
+// file, so Register and Deregister are no-op
+type FileDiscovery struct {
+	Interval time.Duration // interval of checking modification time when watching
+
+	path string
+
+	mu       sync.Mutex
+	last     []*ServerConfig // servers notified last time
+	watchers []func([]*ServerConfig)
+	polling  sync.Once
+}
+
+func NewFileDiscovery(path string) *FileDiscovery {
+	return &FileDiscovery{path: path, Interval: 5 * time.Second}
+}
+
+func (d *FileDiscovery) List() ([]*ServerConfig, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers map[string][]*ServerConfig
+	if err := json.NewDecoder(f).Decode(&servers); err != nil {
+		return nil, err
+	}
+
+	var list []*ServerConfig
+	for typ, svrs := range servers {
+		for _, svr := range svrs {
+			svr.Type = typ
+			list = append(list, svr)
+		}
+	}
+	sortServers(list)
+	return list, nil
+}
+
+// Watch starts polling the file when the first callback registered
+func (d *FileDiscovery) Watch(fn func([]*ServerConfig)) error {
+	servers, err := d.List()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.last == nil {
+		d.last = servers
+	}
+	d.watchers = append(d.watchers, fn)
+	d.mu.Unlock()
+
+	d.polling.Do(func() { go d.poll() })
+	return nil
+}
+
+func (d *FileDiscovery) poll() {
+	var modTime time.Time
+	if fi, err := os.Stat(d.path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	for range time.Tick(d.Interval) {
+		fi, err := os.Stat(d.path)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+
+		if err := d.Reload(); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
+
+// Reload lists servers from the file, and notify all watchers when servers
+// changed
+func (d *FileDiscovery) Reload() error {
+	servers, err := d.List()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if equalServers(d.last, servers) {
+		d.mu.Unlock()
+		return nil
+	}
+	d.last = servers
+	watchers := make([]func([]*ServerConfig), len(d.watchers))
+	copy(watchers, d.watchers)
+	d.mu.Unlock()
+
+	log.Infof("%s reloaded", d.path)
+	for _, fn := range watchers {
+		fn(servers)
+	}
+	return nil
+}
+
+func (d *FileDiscovery) Register(server *ServerConfig) error {
+	return nil
+}
+
+func (d *FileDiscovery) Deregister(id string) error {
+	return nil
+}
+
+// MemoryDiscovery keeps servers in memory, which is useful for testing and
+// embedding cluster in a single process
+type MemoryDiscovery struct {
+	mu       sync.RWMutex
+	servers  map[string]*ServerConfig
+	watchers []func([]*ServerConfig)
+}
+
+func NewMemoryDiscovery(servers ...*ServerConfig) *MemoryDiscovery {
+	d := &MemoryDiscovery{servers: make(map[string]*ServerConfig)}
+	for _, svr := range servers {
+		d.servers[svr.Id] = svr
+	}
+	return d
+}
+
+func (d *MemoryDiscovery) List() ([]*ServerConfig, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.listLocked(), nil
+}
+
+func (d *MemoryDiscovery) listLocked() []*ServerConfig {
+	list := make([]*ServerConfig, 0, len(d.servers))
+	for _, svr := range d.servers {
+		cfg := *svr
+		list = append(list, &cfg)
+	}
+	sortServers(list)
+	return list
+}
+
+func (d *MemoryDiscovery) Watch(fn func([]*ServerConfig)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.watchers = append(d.watchers, fn)
+	return nil
+}
+
+func (d *MemoryDiscovery) Register(server *ServerConfig) error {
+	cfg := *server
+
+	d.mu.Lock()
+	if old, ok := d.servers[cfg.Id]; ok && *old == cfg {
+		d.mu.Unlock()
+		return nil
+	}
+	d.servers[cfg.Id] = &cfg
+	d.notifyLocked()
+	return nil
+}
+
+func (d *MemoryDiscovery) Deregister(id string) error {
+	d.mu.Lock()
+	if _, ok := d.servers[id]; !ok {
+		d.mu.Unlock()
+		return ErrServerNotFound
+	}
+	delete(d.servers, id)
+	d.notifyLocked()
+	return nil
+}
+
+// notify all watchers, unlock before callbacks invoked, so that callbacks
+// can access the discovery
+func (d *MemoryDiscovery) notifyLocked() {
+	servers := d.listLocked()
+	watchers := make([]func([]*ServerConfig), len(d.watchers))
+	copy(watchers, d.watchers)
+	d.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(servers)
+	}
+}
@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lonnng/starx/log"
+)
+
+// DNSDiscovery lists servers from DNS SRV records, servers of each type are
+// looked up by "_<type>._tcp.<domain>", the first label of the SRV target is
+// used as server id, e.g. target "chat-1.starx.local." represents server
+// chat-1 listening at the port of the record. Records are managed by the DNS
+// server, so Register and Deregister are no-op
+type DNSDiscovery struct {
+	Domain    string        // domain of SRV records
+	Types     []string      // server types to look up
+	Frontends []string      // server types which are frontend servers
+	Interval  time.Duration // interval of looking up when watching
+
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func NewDNSDiscovery(domain string, types ...string) *DNSDiscovery {
+	return &DNSDiscovery{
+		Domain:    domain,
+		Types:     types,
+		Interval:  10 * time.Second,
+		lookupSRV: net.LookupSRV,
+	}
+}
+
+func (d *DNSDiscovery) isFrontend(typ string) bool {
+	for _, t := range d.Frontends {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DNSDiscovery) List() ([]*ServerConfig, error) {
+	var list []*ServerConfig
+	for _, typ := range d.Types {
+		_, addrs, err := d.lookupSRV(typ, "tcp", d.Domain)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			list = append(list, &ServerConfig{
+				Type:       typ,
+				Id:         strings.SplitN(host, ".", 2)[0],
+				Host:       host,
+				Port:       int(addr.Port),
+				IsFrontend: d.isFrontend(typ),
+			})
+		}
+	}
+	sortServers(list)
+	return list, nil
+}
+
+// Watch looks up records periodically, the callback will be called when
+// records changed
+func (d *DNSDiscovery) Watch(fn func([]*ServerConfig)) error {
+	last, err := d.List()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(d.Interval) {
+			servers, err := d.List()
+			if err != nil {
+				log.Errorf(err.Error())
+				continue
+			}
+			if equalServers(last, servers) {
+				continue
+			}
+			last = servers
+			fn(servers)
+		}
+	}()
+	return nil
+}
+
+func (d *DNSDiscovery) Register(server *ServerConfig) error {
+	return nil
+}
+
+func (d *DNSDiscovery) Deregister(id string) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryDiscovery(t *testing.T) {
+	d := NewMemoryDiscovery(&ServerConfig{Type: "gate", Id: "gate-1", Port: 3250, IsFrontend: true})
+
+	var notified [][]*ServerConfig
+	d.Watch(func(servers []*ServerConfig) {
+		notified = append(notified, servers)
+	})
+
+	if err := d.Register(&ServerConfig{Type: "chat", Id: "chat-1", Port: 3260}); err != nil {
+		t.Fatal(err)
+	}
+	// register the same config again should not notify watchers
+	if err := d.Register(&ServerConfig{Type: "chat", Id: "chat-1", Port: 3260}); err != nil {
+		t.Fatal(err)
+	}
+
+	servers, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0].Id != "chat-1" || servers[1].Id != "gate-1" {
+		t.Fatalf("unexpected servers: %v", servers)
+	}
+
+	if err := d.Deregister("chat-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deregister("chat-1"); err != ErrServerNotFound {
+		t.Fatalf("expect ErrServerNotFound, got %v", err)
+	}
+
+	if len(notified) != 2 || len(notified[0]) != 2 || len(notified[1]) != 1 {
+		t.Fatalf("unexpected notifications: %v", notified)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "servers.json")
+	data := `{
+		"gate": [{"id": "gate-1", "host": "127.0.0.1", "port": 3250, "is_frontend": true}],
+		"chat": [{"id": "chat-1", "host": "127.0.0.1", "port": 3260}]
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	servers, err := NewFileDiscovery(path).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expect 2 servers, got %d", len(servers))
+	}
+	if servers[0].Id != "chat-1" || servers[0].Type != "chat" || servers[0].IsFrontend {
+		t.Fatalf("unexpected server: %v", servers[0])
+	}
+	if servers[1].Id != "gate-1" || servers[1].Type != "gate" || !servers[1].IsFrontend {
+		t.Fatalf("unexpected server: %v", servers[1])
+	}
+
+	if _, err := NewFileDiscovery(filepath.Join(dir, "missing.json")).List(); err == nil {
+		t.Fail()
+	}
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	d := NewDNSDiscovery("starx.local", "gate", "chat")
+	d.Frontends = []string{"gate"}
+	d.lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if proto != "tcp" || name != "starx.local" {
+			t.Fatalf("unexpected lookup: %s %s %s", service, proto, name)
+		}
+		switch service {
+		case "gate":
+			return "", []*net.SRV{{Target: "gate-1.starx.local.", Port: 3250}}, nil
+		default:
+			return "", []*net.SRV{
+				{Target: "chat-2.starx.local.", Port: 3261},
+				{Target: "chat-1.starx.local.", Port: 3260},
+			}, nil
+		}
+	}
+
+	servers, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 3 {
+		t.Fatalf("expect 3 servers, got %d", len(servers))
+	}
+
+	expect := []ServerConfig{
+		{Type: "chat", Id: "chat-1", Host: "chat-1.starx.local", Port: 3260},
+		{Type: "chat", Id: "chat-2", Host: "chat-2.starx.local", Port: 3261},
+		{Type: "gate", Id: "gate-1", Host: "gate-1.starx.local", Port: 3250, IsFrontend: true},
+	}
+	for i := range expect {
+		if *servers[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect[i], servers[i])
+		}
+	}
+}
+
+func TestFileDiscovery_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "servers.json")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"chat": [{"id": "chat-1", "host": "127.0.0.1", "port": 3260}]}`)
+
+	d := NewFileDiscovery(path)
+	d.Interval = time.Hour
+
+	var notified [][]*ServerConfig
+	if err := d.Watch(func(servers []*ServerConfig) {
+		notified = append(notified, servers)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// unchanged
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 0 {
+		t.Fatalf("unexpected notifications: %v", notified)
+	}
+
+	write(`{"chat": [{"id": "chat-1", "host": "127.0.0.1", "port": 3261}, {"id": "chat-2", "host": "127.0.0.1", "port": 3262}]}`)
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || len(notified[0]) != 2 || notified[0][0].Port != 3261 {
+		t.Fatalf("unexpected notifications: %v", notified)
+	}
+
+	// invalid file should be reported and keep the last servers
+	write(`{"chat": [`)
+	if err := d.Reload(); err == nil {
+		t.Fail()
+	}
+	if len(notified) != 1 {
+		t.Fatalf("unexpected notifications: %v", notified)
+	}
+}
@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lonnng/starx/log"
+)
+
+// Health represents the connection state of a server
+type Health int
+
+const (
+	// HealthUp represents the server is connected or has not been connected
+	HealthUp Health = iota
+	// HealthSuspect represents the connection broken, and reconnecting
+	HealthSuspect
+	// HealthDown represents the server has failed to reconnect for several
+	// times, it is still reconnected with a longer interval
+	HealthDown
+)
+
+var healthNames = map[Health]string{
+	HealthUp:      "up",
+	HealthSuspect: "suspect",
+	HealthDown:    "down",
+}
+
+func (h Health) String() string {
+	return healthNames[h]
+}
+
+var (
+	// health of servers, servers not contained are up
+	health = &struct {
+		sync.RWMutex
+		servers map[string]Health
+	}{servers: make(map[string]Health)}
+
+	// backoff of reconnecting, doubles after every failure until max
+	backoff = &struct {
+		sync.RWMutex
+		min, max time.Duration
+	}{min: 100 * time.Millisecond, max: 30 * time.Second}
+
+	reconnectDownTimes = 3 // server is marked as down after failed times
+)
+
+// SetReconnectBackoff set the backoff of reconnecting server when rpc
+// connection broken, backoff doubles after every failure until max
+func SetReconnectBackoff(min, max time.Duration) {
+	backoff.Lock()
+	defer backoff.Unlock()
+
+	backoff.min, backoff.max = min, max
+}
+
+// ServerHealth returns the health of server
+func ServerHealth(svrId string) Health {
+	health.RLock()
+	defer health.RUnlock()
+
+	return health.servers[svrId]
+}
+
+func setHealth(svrId string, h Health) {
+	health.Lock()
+	defer health.Unlock()
+
+	if h == HealthUp {
+		delete(health.servers, svrId)
+	} else {
+		health.servers[svrId] = h
+	}
+}
+
+// set health of the server only when it has not been removed, so health
+// reset by RemoveServer will not be overwritten by reconnecting
+func setServerHealth(svrId string, h Health) bool {
+	svrLock.RLock()
+	defer svrLock.RUnlock()
+
+	if _, ok := svrIdMaps[svrId]; !ok {
+		return false
+	}
+	setHealth(svrId, h)
+	return true
+}
+
+// routable returns whether requests can be routed to the server, down and
+// removed servers are not routable
+func routable(svrId string) bool {
+	if _, err := Server(svrId); err != nil {
+		return false
+	}
+	return ServerHealth(svrId) != HealthDown
+}
+
+// filter out unhealthy servers, suspect servers are used when there is not
+// any up server
+func healthyServers(servers []*ServerConfig) []*ServerConfig {
+	var up, suspect []*ServerConfig
+	for _, svr := range servers {
+		switch ServerHealth(svr.Id) {
+		case HealthUp:
+			up = append(up, svr)
+		case HealthSuspect:
+			suspect = append(suspect, svr)
+		}
+	}
+
+	if len(up) > 0 {
+		return up
+	}
+	return suspect
+}
+
+// backoff with jitter in [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// reconnect server with exponential backoff until the connection restored or
+// the server removed from cluster
+func reconnect(svrId string) {
+	log.Infof("%s rpc connection broken, reconnecting", svrId)
+	if !setServerHealth(svrId, HealthSuspect) {
+		log.Infof("%s has been removed, stop reconnecting", svrId)
+		return
+	}
+
+	backoff.RLock()
+	d, max := backoff.min, backoff.max
+	backoff.RUnlock()
+
+	for failures := 1; ; failures++ {
+		time.Sleep(jitter(d))
+
+		svr, err := Server(svrId)
+		if err != nil {
+			log.Infof("%s has been removed, stop reconnecting", svrId)
+			return
+		}
+
+		if _, err := dial(svr); err == nil {
+			setServerHealth(svrId, HealthUp)
+			log.Infof("%s rpc connection restored", svrId)
+			return
+		}
+
+		if failures == reconnectDownTimes {
+			if !setServerHealth(svrId, HealthDown) {
+				log.Infof("%s has been removed, stop reconnecting", svrId)
+				return
+			}
+			log.Infof("%s is down", svrId)
+		}
+
+		if d *= 2; d > max {
+			d = max
+		}
+	}
+}
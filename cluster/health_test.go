@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/session"
+)
+
+func waitHealth(id string, h Health) bool {
+	for i := 0; i < 200; i++ {
+		if ServerHealth(id) == h {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReconnect(t *testing.T) {
+	SetReconnectBackoff(time.Millisecond, 5*time.Millisecond)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	SetAppConfig(&ServerConfig{Type: "gate", Id: "gate-1", IsFrontend: true})
+	defer SetAppConfig(nil)
+	Register(&ServerConfig{Type: "health", Id: "health-1", Host: "127.0.0.1", Port: port})
+	Register(&ServerConfig{Type: "health", Id: "health-2", Host: "127.0.0.1", Port: 1})
+	defer RemoveServer("health-1")
+	defer RemoveServer("health-2")
+
+	if _, err := Client("health-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// connection broken, server should be kept and reconnected
+	(<-conns).Close()
+	var conn net.Conn
+	select {
+	case conn = <-conns:
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+	if !waitHealth("health-1", HealthUp) {
+		t.Fatalf("expect up, got %s", ServerHealth("health-1"))
+	}
+	if _, err := Server("health-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// server stopped, marked as down after failures
+	ln.Close()
+	conn.Close()
+
+	if !waitHealth("health-1", HealthDown) {
+		t.Fatalf("expect down, got %s", ServerHealth("health-1"))
+	}
+	if _, err := Client("health-1"); err != ErrServerUnavailable {
+		t.Fatalf("expect ErrServerUnavailable, got %v", err)
+	}
+
+	// down server should be skipped
+	setHealth("health-2", HealthUp)
+	if servers := healthyServers(serversByType("health")); len(servers) != 1 || servers[0].Id != "health-2" {
+		t.Fatalf("unexpected healthy servers: %v", servers)
+	}
+	// session routed to down server is routed to another server
+	s := session.New(nil)
+	s.SetServerID("health", "health-1")
+	if _, err := ClientByType("health", s); err == nil {
+		t.Fatal("expect dial error")
+	}
+	if s.ServerID("health") != "health-2" {
+		t.Fatalf("expect health-2, got %s", s.ServerID("health"))
+	}
+}
+
+func TestReconnect_Removed(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	SetAppConfig(&ServerConfig{Type: "gate", Id: "gate-1", IsFrontend: true})
+	defer SetAppConfig(nil)
+	svr := &ServerConfig{Type: "health", Id: "health-3", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port}
+	Register(svr)
+
+	// reconnecting finished after server removed
+	RemoveServer("health-3")
+	if setServerHealth("health-3", HealthDown) || ServerHealth("health-3") != HealthUp {
+		t.Fatal("health of removed server should not be changed")
+	}
+
+	// server added again with the same id
+	Register(svr)
+	defer RemoveServer("health-3")
+	if _, err := Client("health-3"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/lonnng/starx/session"
 )
 
+// Manager maintains cluster membership through server requests.
+//
+// Deprecated: membership is maintained by master server in cluster mode,
+// Manager is kept for compatibility and will be removed in a later release.
 type Manager struct {
 	Name    string
 	Counter int
@@ -23,7 +27,7 @@ func (*Manager) Shutdown()       {}
 
 // attachment methods
 func (m *Manager) UpdateServer(session *session.Session, data []byte) error {
-	var newServerInfo *ServerConfig
+	newServerInfo := &ServerConfig{}
 	err := json.Unmarshal(data, newServerInfo)
 	if err != nil {
 		return err
@@ -33,7 +37,7 @@ func (m *Manager) UpdateServer(session *session.Session, data []byte) error {
 }
 
 func (m *Manager) RegisterServer(session *session.Session, data []byte) error {
-	var newServerInfo *ServerConfig
+	newServerInfo := &ServerConfig{}
 	err := json.Unmarshal(data, newServerInfo)
 	if err != nil {
 		return err
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lonnng/starx/cluster/rpc"
+)
+
+// amount of rpc connections to each backend server
+var poolSize int32 = 1
+
+// SetPoolSize set the amount of rpc connections to each backend server, which
+// takes effect on the next connecting
+func SetPoolSize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt32(&poolSize, int32(n))
+}
+
+// pool represents rpc connections to a backend server, requests of the same
+// session are pinned to one connection, because backend session is bound to
+// the connection and requests should be handled in order, other requests are
+// sent through the connection with the least pending calls
+type pool struct {
+	clients []*rpc.Client
+
+	mu     sync.Mutex
+	pinned map[int64]*rpc.Client // session id -> pinned connection
+	synced map[int64]bool        // session id -> whether state pushed to the server
+}
+
+func newPool(clients []*rpc.Client) *pool {
+	return &pool{
+		clients: clients,
+		pinned:  make(map[int64]*rpc.Client),
+		synced:  make(map[int64]bool),
+	}
+}
+
+func (p *pool) leastPending() *rpc.Client {
+	selected := p.clients[0]
+	min := selected.Pending()
+	for _, c := range p.clients[1:] {
+		if n := c.Pending(); n < min {
+			selected, min = c, n
+		}
+	}
+	return selected
+}
+
+// returns the connection of the session, zero sid represents the request does
+// not belong to any session
+func (p *pool) client(sid int64) *rpc.Client {
+	if sid == 0 {
+		return p.leastPending()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.pinned[sid]; ok {
+		return c
+	}
+	c := p.leastPending()
+	p.pinned[sid] = c
+	return c
+}
+
+// whether the session state has been pushed through the connections, backend
+// sessions are closed with the connections, so state of all sessions should
+// be pushed again after reconnected
+func (p *pool) isSynced(sid int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.synced[sid]
+}
+
+func (p *pool) setSynced(sid int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.synced[sid] = true
+}
+
+// release the pinned connection of the closed session
+func (p *pool) release(sid int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pinned, sid)
+	delete(p.synced, sid)
+}
+
+func (p *pool) close() {
+	for _, c := range p.clients {
+		c.Close()
+	}
+}
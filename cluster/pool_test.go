@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/cluster/rpc"
+)
+
+func TestPool(t *testing.T) {
+	SetPoolSize(3)
+	defer SetPoolSize(1)
+	SetReconnectBackoff(time.Millisecond, 5*time.Millisecond)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// server never replies
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func() {
+				for {
+					if _, err := rpc.ReadRequest(conn); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	SetAppConfig(&ServerConfig{Type: "gate", Id: "gate-1", IsFrontend: true})
+	defer SetAppConfig(nil)
+	Register(&ServerConfig{Type: "pool", Id: "pool-1", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port})
+	defer RemoveServer("pool-1")
+
+	c1, err := SessionClient("pool-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex.RLock()
+	p := clientIdMaps["pool-1"]
+	mutex.RUnlock()
+	if len(p.clients) != 3 {
+		t.Fatalf("expect 3 connections, got %d", len(p.clients))
+	}
+
+	// pinned connection of session
+	c1.Go(rpc.User, "Test", "Hang", 1, new([]byte), nil, nil)
+	if c, _ := SessionClient("pool-1", 1); c != c1 {
+		t.Fatal("session should be pinned to the connection")
+	}
+
+	// least pending connection selected by other sessions
+	c2, _ := SessionClient("pool-1", 2)
+	if c2 == c1 {
+		t.Fatal("busy connection selected")
+	}
+	c2.Go(rpc.User, "Test", "Hang", 2, new([]byte), nil, nil)
+	c0, _ := Client("pool-1")
+	if c0 == c1 || c0 == c2 {
+		t.Fatal("busy connection selected")
+	}
+
+	// pinned session is not synced until state pushed
+	if p.isSynced(2) {
+		t.Fatal("session should not be synced by pinning")
+	}
+	p.setSynced(2)
+
+	releaseSession(1)
+	p.mu.Lock()
+	_, ok := p.pinned[1]
+	p.mu.Unlock()
+	if ok {
+		t.Fatal("pinned connection not released")
+	}
+
+	// any connection broken, all connections reconnected
+	(<-conns).Close()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-conns:
+		case <-time.After(time.Second):
+			t.Fatal("not reconnected")
+		}
+	}
+	if !waitHealth("pool-1", HealthUp) {
+		t.Fatalf("expect up, got %s", ServerHealth("pool-1"))
+	}
+	mutex.RLock()
+	np := clientIdMaps["pool-1"]
+	mutex.RUnlock()
+	if np == nil || np == p || len(np.clients) != 3 {
+		t.Fatal("pool not reconnected")
+	}
+
+	// backend sessions closed with the broken connections, state should be
+	// pushed again
+	if _, p, _ := sessionClient("pool-1", 2); p != np || p.isSynced(2) {
+		t.Fatal("session should not be synced to the reconnected pool")
+	}
+}
@@ -1,15 +1,54 @@
 package cluster
 
 import (
+	"math/rand"
 	"strings"
+	"sync"
 
 	"github.com/lonnng/starx/session"
 )
 
-var router map[string]func(*session.Session) string
+var (
+	routerLock sync.RWMutex
+	router     = make(map[string]func(*session.Session) string)
+	balancers  = make(map[string]Balancer)
+)
 
 func Router(svrType string, fn func(*session.Session) string) {
 	if t := strings.TrimSpace(svrType); t != "" {
-		router[svrType] = fn
+		routerLock.Lock()
+		defer routerLock.Unlock()
+
+		router[t] = fn
+	}
+}
+
+// SetBalancer set the strategy of selecting server of the type, router set
+// by Router takes precedence over balancer
+func SetBalancer(svrType string, b Balancer) {
+	if t := strings.TrimSpace(svrType); t != "" {
+		routerLock.Lock()
+		defer routerLock.Unlock()
+
+		balancers[t] = b
+	}
+}
+
+// select a server for the session from servers of the type, via router or
+// balancer of the type, or select a random server when neither of them set,
+// router is skipped when session is nil
+func selectServer(svrType string, servers []*ServerConfig, s *session.Session) string {
+	routerLock.RLock()
+	fn := router[svrType]
+	b := balancers[svrType]
+	routerLock.RUnlock()
+
+	switch {
+	case fn != nil && s != nil:
+		return fn(s)
+	case b != nil:
+		return b.Select(servers, s)
+	default:
+		return servers[rand.Intn(len(servers))].Id
 	}
 }
@@ -1,8 +1,9 @@
 package rpc
 
 import (
+	"bufio"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -23,6 +24,7 @@ var (
 	ErrRequestOverFlow = errors.New("request too long")
 	ErrEmptyBuffer     = errors.New("empty buffer")
 	ErrTruncedBuffer   = errors.New("buffer length less than response length")
+	ErrTimeout         = errors.New("rpc call timeout")
 )
 
 var debugLog = false
@@ -36,6 +38,8 @@ type Call struct {
 	Reply         *[]byte    // The reply from the function.
 	Error         error      // After completion, the error status.
 	Done          chan *Call // Strobes when call is complete.
+
+	seq uint64 // sequence of pending call
 }
 
 // Client represents an RPC Client.
@@ -54,7 +58,7 @@ type Client struct {
 	closing          bool           // user has called Close
 	shutdown         bool           // server has told us to stop
 	shutdownCallback func()         // callback on client shutdown
-	ResponseChan     chan *Response // rpc response handler
+	ResponseChan     chan *Response // rpc response handler, closed when connection broken
 }
 
 // A ClientCodec implements writing of RPC requests and
@@ -66,8 +70,7 @@ type Client struct {
 // argument to force the body of the response to be read and then
 // discarded.
 type clientCodec struct {
-	rw  io.ReadWriteCloser
-	buf []byte // save buffer data
+	rw io.ReadWriteCloser
 }
 
 func (codec *clientCodec) close() error {
@@ -75,13 +78,32 @@ func (codec *clientCodec) close() error {
 }
 
 func (client *Client) writeRequest() error {
-	data, err := client.request.MarshalMsg(emptyBytes)
+	return WriteRequest(client.codec.rw, &client.request)
+}
+
+// WriteRequest write the request as a frame
+func WriteRequest(w io.Writer, req *Request) error {
+	data, err := req.MarshalMsg(emptyBytes)
 	if err != nil {
 		log.Errorf(err.Error())
 		return err
 	}
-	_, err = client.codec.rw.Write(data)
-	return err
+	return WriteFrame(w, data)
+}
+
+// ReadResponse read a response frame, the connection should be closed when
+// error returned
+func ReadResponse(r io.Reader) (*Response, error) {
+	data, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{}
+	if _, err := resp.UnmarshalMsg(data); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
 func (client *Client) send(rpcKind RpcKind, call *Call) {
@@ -97,6 +119,7 @@ func (client *Client) send(rpcKind RpcKind, call *Call) {
 		return
 	}
 	seq := client.seq
+	call.seq = seq
 	if call.Reply != nil {
 		client.seq++
 		client.pending[seq] = call
@@ -110,8 +133,20 @@ func (client *Client) send(rpcKind RpcKind, call *Call) {
 	client.request.Kind = rpcKind
 	client.request.Sid = call.Sid
 
-	if err := client.writeRequest(); err != nil {
+	err := client.writeRequest()
+	if err != nil {
 		log.Errorf(err.Error())
+	}
+
+	// the call which does not need reply has been completed after the
+	// request written
+	if call.Reply == nil {
+		call.Error = err
+		call.done()
+		return
+	}
+
+	if err != nil {
 		client.mutex.Lock()
 		call = client.pending[seq]
 		delete(client.pending, seq)
@@ -126,62 +161,53 @@ func (client *Client) send(rpcKind RpcKind, call *Call) {
 func (client *Client) input() {
 	var err error
 	var response *Response
-	var tmp = make([]byte, 512)
+	reader := bufio.NewReader(client.codec.rw)
 	for err == nil {
-		n, err := client.codec.rw.Read(tmp)
+		response, err = ReadResponse(reader)
 		if err != nil {
-			fmt.Println(err.Error())
 			break
 		}
-		client.codec.buf = append(client.codec.buf, tmp[:n]...)
-		for {
-			response = &Response{}
-			client.codec.buf, err = response.UnmarshalMsg(client.codec.buf)
-			if err != nil {
-				//log.Error(err.Error())
-				break
-			}
-			if response.Kind == HandlerPush || response.Kind == HandlerResponse {
-				client.ResponseChan <- response
-				continue
-			}
-			seq := response.Seq
-			client.mutex.Lock()
-			call := client.pending[seq]
-			delete(client.pending, seq)
-			client.mutex.Unlock()
-
-			switch {
-			case call == nil:
-				// We've got no pending call. That usually means that
-				// WriteRequest partially failed, and call was already
-				// removed; response is a server telling us about an
-				// error reading request body. We should still attempt
-				// to read error body, but there's no one to give it to.
-				// err = errors.New("reading error body")
-			case response.Error != "":
-				// We've got an error response. Give this to the request;
-				// any subsequent requests will get the ReadResponseBody
-				// error if there is one.
-				call.Error = ServerError(response.Error)
-				if err != nil {
-					err = errors.New("reading error body: " + err.Error())
-				}
-				call.done()
-			default:
-				if err != nil {
-					call.Error = errors.New("reading body " + err.Error())
-				}
-				*call.Reply = response.Data
-				call.done()
-			}
+		// all responses except remote response are initiated by remote server
+		if response.Kind != RemoteResponse {
+			client.ResponseChan <- response
+			continue
+		}
+		seq := response.Seq
+		client.mutex.Lock()
+		call := client.pending[seq]
+		delete(client.pending, seq)
+		client.mutex.Unlock()
+
+		switch {
+		case call == nil:
+			// We've got no pending call. That usually means that
+			// WriteRequest partially failed, and call was already
+			// removed, or the call has been canceled.
+		case response.Error != "":
+			// We've got an error response. Give this to the request.
+			call.Error = ServerError(response.Error)
+			call.done()
+		default:
+			*call.Reply = response.Data
+			call.done()
 		}
 	}
+	// no more responses, stop the response handler
+	close(client.ResponseChan)
+
+	// Close the connection on malformed frame
+	client.mutex.Lock()
+	closing := client.closing
+	client.mutex.Unlock()
+	if err != io.EOF && !closing {
+		log.Errorf("rpc: read response failed: %s", err.Error())
+		client.codec.close()
+	}
 	// Terminate pending calls.
 	client.reqMutex.Lock()
 	client.mutex.Lock()
 	client.shutdown = true
-	closing := client.closing
+	closing = client.closing
 	if err == io.EOF {
 		if closing {
 			err = ErrShutdown
@@ -193,13 +219,14 @@ func (client *Client) input() {
 		call.Error = err
 		call.done()
 	}
+	callback := client.shutdownCallback
 	client.mutex.Unlock()
 	client.reqMutex.Unlock()
 	if debugLog && err != io.EOF && !closing {
 		log.Errorf("rpc: client protocol error:", err)
 	}
-	if client.shutdownCallback != nil {
-		client.shutdownCallback()
+	if callback != nil {
+		callback()
 	}
 }
 
@@ -222,10 +249,7 @@ func (call *Call) done() {
 // the header and payload are sent as a unit.
 func NewClient(conn io.ReadWriteCloser) *Client {
 	client := &Client{
-		codec: &clientCodec{
-			rw:  conn,
-			buf: make([]byte, 0),
-		},
+		codec:        &clientCodec{rw: conn},
 		pending:      make(map[uint64]*Call),
 		ResponseChan: make(chan *Response, 2<<10),
 	}
@@ -242,9 +266,17 @@ func Dial(network, address string) (*Client, error) {
 	return NewClient(conn), nil
 }
 
-// client shutdown callback function
+// client shutdown callback function, callback will be called immediately when
+// the client has been shut down
 func (client *Client) OnShutdown(callback func()) {
+	client.mutex.Lock()
 	client.shutdownCallback = callback
+	shutdown := client.shutdown
+	client.mutex.Unlock()
+
+	if shutdown {
+		callback()
+	}
 }
 
 func (client *Client) Close() error {
@@ -258,6 +290,14 @@ func (client *Client) Close() error {
 	return client.codec.close()
 }
 
+// Pending returns the amount of calls waiting for reply
+func (client *Client) Pending() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return len(client.pending)
+}
+
 // Go invokes the function asynchronously.  It returns the Call structure representing
 // the invocation.  The done channel will signal when the call is complete by returning
 // the same Call object.  If done is nil, Go will allocate a new channel.
@@ -289,3 +329,25 @@ func (client *Client) Call(rpcKind RpcKind, service string, method string, sid i
 	call := <-client.Go(rpcKind, service, method, sid, reply, make(chan *Call, 1), args).Done
 	return call.Error
 }
+
+// CallContext invokes the named function, waits for it to complete or the
+// context done, the pending call will be removed when context done, and
+// ErrTimeout returned when deadline exceeded
+func (client *Client) CallContext(ctx context.Context, rpcKind RpcKind, service string, method string, sid int64, reply *[]byte, args []byte) error {
+	call := client.Go(rpcKind, service, method, sid, reply, make(chan *Call, 1), args)
+	select {
+	case call = <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		client.mutex.Lock()
+		if client.pending[call.seq] == call {
+			delete(client.pending, call.seq)
+		}
+		client.mutex.Unlock()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrTimeout
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve reads requests from conn, and replies the request data when reply
+// is true
+func serve(t *testing.T, conn net.Conn, reply bool) {
+	for {
+		req, err := ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		if !reply {
+			continue
+		}
+
+		resp := &Response{Kind: RemoteResponse, Seq: req.Seq, Data: req.Data}
+		if err := WriteResponse(conn, resp); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestClient_CallContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go serve(t, c2, true)
+
+	client := NewClient(c1)
+	reply := new([]byte)
+	err := client.CallContext(context.Background(), User, "Test", "Echo", 1, reply, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(*reply) != "hello" {
+		t.Fatalf("unexpected reply: %s", *reply)
+	}
+}
+
+func TestClient_CallContextTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go serve(t, c2, false)
+
+	client := NewClient(c1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.CallContext(ctx, User, "Test", "Hang", 1, new([]byte), nil); err != ErrTimeout {
+		t.Fatalf("expect ErrTimeout, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := client.CallContext(ctx, User, "Test", "Hang", 1, new([]byte), nil); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.pending) != 0 {
+		t.Fatalf("pending calls not removed: %d", len(client.pending))
+	}
+}
+
+func TestClient_ResponseChanClosed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewClient(c1)
+
+	go WriteResponse(c2, &Response{Kind: ClusterUpdate, Data: []byte("[]")})
+	if resp := <-client.ResponseChan; resp == nil || resp.Kind != ClusterUpdate {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	// connection broken
+	c2.Close()
+	select {
+	case _, ok := <-client.ResponseChan:
+		if ok {
+			t.Fatal("unexpected response")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("response channel should be closed")
+	}
+}
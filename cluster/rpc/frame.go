@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
+// Frame represents a request or response on the wire:
+//
+//	| version(1) | flags(1) | length(4) | payload(length) | checksum(4) |
+//
+// length is big endian, the crc32 checksum of payload is only present when
+// FlagChecksum set
+const (
+	FrameVersion    = 0x1
+	FrameHeadLength = 6
+
+	FlagChecksum = 0x1
+
+	DefaultMaxFrameSize = 16 << 20
+)
+
+var (
+	ErrFrameVersion  = errors.New("rpc: invalid frame version")
+	ErrFrameFlags    = errors.New("rpc: invalid frame flags")
+	ErrFrameTooLarge = errors.New("rpc: frame too large")
+	ErrFrameChecksum = errors.New("rpc: frame checksum mismatch")
+)
+
+var (
+	maxFrameSize int64 = DefaultMaxFrameSize
+	checksum     int32 // write checksum when not zero
+)
+
+// SetMaxFrameSize set the max payload size of frames, both reading and
+// writing larger frames fail with ErrFrameTooLarge
+func SetMaxFrameSize(size int) {
+	atomic.StoreInt64(&maxFrameSize, int64(size))
+}
+
+// EnableChecksum set whether write checksum of frames, frames with checksum
+// are always verified when reading
+func EnableChecksum(enable bool) {
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&checksum, v)
+}
+
+// WriteFrame write payload as a frame in a single write
+func WriteFrame(w io.Writer, payload []byte) error {
+	if int64(len(payload)) > atomic.LoadInt64(&maxFrameSize) {
+		return ErrFrameTooLarge
+	}
+
+	var flags byte
+	size := FrameHeadLength + len(payload)
+	if atomic.LoadInt32(&checksum) != 0 {
+		flags |= FlagChecksum
+		size += 4
+	}
+
+	buf := make([]byte, size)
+	buf[0] = FrameVersion
+	buf[1] = flags
+	binary.BigEndian.PutUint32(buf[2:], uint32(len(payload)))
+	copy(buf[FrameHeadLength:], payload)
+	if flags&FlagChecksum != 0 {
+		binary.BigEndian.PutUint32(buf[FrameHeadLength+len(payload):], crc32.ChecksumIEEE(payload))
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame read a frame and returns its payload, io.EOF returned when
+// reader closed between frames, and io.ErrUnexpectedEOF returned when the
+// frame truncated, the connection should be closed when any error returned
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var head [FrameHeadLength]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	if head[0] != FrameVersion {
+		return nil, ErrFrameVersion
+	}
+
+	flags := head[1]
+	if flags&^FlagChecksum != 0 {
+		return nil, ErrFrameFlags
+	}
+
+	length := binary.BigEndian.Uint32(head[2:])
+	if int64(length) > atomic.LoadInt64(&maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	size := int(length)
+	if flags&FlagChecksum != 0 {
+		size += 4
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	payload := buf[:length]
+	if flags&FlagChecksum != 0 && binary.BigEndian.Uint32(buf[length:]) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrFrameChecksum
+	}
+	return payload, nil
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func frame(t testing.TB, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	if err := WriteFrame(buf, payload); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrame(t *testing.T) {
+	defer EnableChecksum(false)
+
+	for _, sum := range []bool{false, true} {
+		EnableChecksum(sum)
+
+		buf := &bytes.Buffer{}
+		payloads := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 100000)}
+		for _, p := range payloads {
+			if err := WriteFrame(buf, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, p := range payloads {
+			data, err := ReadFrame(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, p) {
+				t.Fatalf("checksum: %t, expect %d bytes, got %d", sum, len(p), len(data))
+			}
+		}
+
+		if _, err := ReadFrame(buf); err != io.EOF {
+			t.Fatalf("expect io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestFrame_Malformed(t *testing.T) {
+	defer EnableChecksum(false)
+	defer SetMaxFrameSize(DefaultMaxFrameSize)
+
+	data := frame(t, []byte("hello"))
+
+	bad := append([]byte{}, data...)
+	bad[0] = 0x2
+	if _, err := ReadFrame(bytes.NewReader(bad)); err != ErrFrameVersion {
+		t.Fatalf("expect ErrFrameVersion, got %v", err)
+	}
+
+	bad = append([]byte{}, data...)
+	bad[1] = 0x80
+	if _, err := ReadFrame(bytes.NewReader(bad)); err != ErrFrameFlags {
+		t.Fatalf("expect ErrFrameFlags, got %v", err)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader(data[:3])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(data[:len(data)-1])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	SetMaxFrameSize(4)
+	if _, err := ReadFrame(bytes.NewReader(data)); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+	if err := WriteFrame(&bytes.Buffer{}, []byte("hello")); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+	SetMaxFrameSize(DefaultMaxFrameSize)
+
+	EnableChecksum(true)
+	bad = frame(t, []byte("hello"))
+	bad[FrameHeadLength] ^= 0xff
+	if _, err := ReadFrame(bytes.NewReader(bad)); err != ErrFrameChecksum {
+		t.Fatalf("expect ErrFrameChecksum, got %v", err)
+	}
+}
+
+// corrupt valid frames randomly, decoder should never panic, and corrupted
+// payload should always be detected when checksum enabled
+func TestFrame_Corrupted(t *testing.T) {
+	EnableChecksum(true)
+	defer EnableChecksum(false)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		payload := make([]byte, r.Intn(64))
+		r.Read(payload)
+		data := frame(t, payload)
+
+		pos := r.Intn(len(data))
+		data[pos] ^= byte(r.Intn(255) + 1)
+
+		got, err := ReadFrame(bytes.NewReader(data))
+		if err == nil && bytes.Equal(got, payload) {
+			t.Fatalf("corruption at %d not detected: %v", pos, data)
+		}
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(frame(f, []byte("hello")))
+	f.Add([]byte{FrameVersion, FlagChecksum, 0, 0, 0, 1, 'x', 0, 0, 0, 0})
+	f.Add([]byte{FrameVersion, 0, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			if _, err := ReadFrame(r); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestClient_MalformedFrame(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	client := NewClient(c1)
+	go func() {
+		ReadRequest(c2)
+		c2.Write([]byte{0x7f, 0, 0, 0, 0, 0})
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(User, "Test", "Echo", 1, new([]byte), []byte("hello"))
+	}()
+
+	select {
+	case err := <-done:
+		if err != ErrFrameVersion {
+			t.Fatalf("expect ErrFrameVersion, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("malformed frame stalled the connection")
+	}
+}
@@ -8,6 +8,10 @@ const (
 	HandlerPush                  = 0x2 // handler session push
 	RemoteResponse               = 0x3 // remote request normal response, represent whether rpc call successfully
 	RemotePush                   = 0x4 // using remote server push message to current server
+	SessionSync                  = 0x5 // backend session push uid and session data to frontend session
+	RemoteKick                   = 0x6 // using remote server kick session in current server
+	ClusterUpdate                = 0x7 // master server push the latest cluster membership
+	LoadReport                   = 0x8 // backend server report its load periodically
 )
 
 type RpcKind byte
@@ -40,4 +44,5 @@ type Response struct {
 	Data          []byte       // save response value
 	Error         string       // error, if any.
 	Route         string       // exists when ResponseType equal RPC_HANDLER_PUSH
+	Sids          []int64      // frontend session ids, exists when remote push to multiple sessions
 }
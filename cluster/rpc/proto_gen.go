@@ -252,6 +252,23 @@ func (z *Response) DecodeMsg(dc *msgp.Reader) (err error) {
 			if err != nil {
 				return
 			}
+		case "Sids":
+			var zlsx uint32
+			zlsx, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Sids) >= int(zlsx) {
+				z.Sids = (z.Sids)[:zlsx]
+			} else {
+				z.Sids = make([]int64, zlsx)
+			}
+			for zpez := range z.Sids {
+				z.Sids[zpez], err = dc.ReadInt64()
+				if err != nil {
+					return
+				}
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -264,9 +281,9 @@ func (z *Response) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Response) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 7
+	// map header, size 8
 	// write "Kind"
-	err = en.Append(0x87, 0xa4, 0x4b, 0x69, 0x6e, 0x64)
+	err = en.Append(0x88, 0xa4, 0x4b, 0x69, 0x6e, 0x64)
 	if err != nil {
 		return err
 	}
@@ -328,15 +345,30 @@ func (z *Response) EncodeMsg(en *msgp.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	// write "Sids"
+	err = en.Append(0xa4, 0x53, 0x69, 0x64, 0x73)
+	if err != nil {
+		return err
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Sids)))
+	if err != nil {
+		return
+	}
+	for zqke := range z.Sids {
+		err = en.WriteInt64(z.Sids[zqke])
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *Response) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 7
+	// map header, size 8
 	// string "Kind"
-	o = append(o, 0x87, 0xa4, 0x4b, 0x69, 0x6e, 0x64)
+	o = append(o, 0x88, 0xa4, 0x4b, 0x69, 0x6e, 0x64)
 	o = msgp.AppendByte(o, byte(z.Kind))
 	// string "ServiceMethod"
 	o = append(o, 0xad, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64)
@@ -356,6 +388,12 @@ func (z *Response) MarshalMsg(b []byte) (o []byte, err error) {
 	// string "Route"
 	o = append(o, 0xa5, 0x52, 0x6f, 0x75, 0x74, 0x65)
 	o = msgp.AppendString(o, z.Route)
+	// string "Sids"
+	o = append(o, 0xa4, 0x53, 0x69, 0x64, 0x73)
+	o = msgp.AppendArrayHeader(o, uint32(len(z.Sids)))
+	for zqke := range z.Sids {
+		o = msgp.AppendInt64(o, z.Sids[zqke])
+	}
 	return
 }
 
@@ -414,6 +452,23 @@ func (z *Response) UnmarshalMsg(bts []byte) (o []byte, err error) {
 			if err != nil {
 				return
 			}
+		case "Sids":
+			var zqyh uint32
+			zqyh, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				return
+			}
+			if cap(z.Sids) >= int(zqyh) {
+				z.Sids = (z.Sids)[:zqyh]
+			} else {
+				z.Sids = make([]int64, zqyh)
+			}
+			for zpez := range z.Sids {
+				z.Sids[zpez], bts, err = msgp.ReadInt64Bytes(bts)
+				if err != nil {
+					return
+				}
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -426,7 +481,7 @@ func (z *Response) UnmarshalMsg(bts []byte) (o []byte, err error) {
 }
 
 func (z *Response) Msgsize() (s int) {
-	s = 1 + 5 + msgp.ByteSize + 14 + msgp.StringPrefixSize + len(z.ServiceMethod) + 4 + msgp.Uint64Size + 4 + msgp.Int64Size + 5 + msgp.BytesPrefixSize + len(z.Data) + 6 + msgp.StringPrefixSize + len(z.Error) + 6 + msgp.StringPrefixSize + len(z.Route)
+	s = 1 + 5 + msgp.ByteSize + 14 + msgp.StringPrefixSize + len(z.ServiceMethod) + 4 + msgp.Uint64Size + 4 + msgp.Int64Size + 5 + msgp.BytesPrefixSize + len(z.Data) + 6 + msgp.StringPrefixSize + len(z.Error) + 6 + msgp.StringPrefixSize + len(z.Route) + 5 + msgp.ArrayHeaderSize + (len(z.Sids) * (msgp.Int64Size))
 	return
 }
 
@@ -40,6 +40,11 @@ var rpcResponseKindNames = []string{
 	HandlerResponse: "HandlerResponse",
 	HandlerPush:     "HandlerPush",
 	RemoteResponse:  "RemoteResponse",
+	RemotePush:      "RemotePush",
+	SessionSync:     "SessionSync",
+	RemoteKick:      "RemoteKick",
+	ClusterUpdate:   "ClusterUpdate",
+	LoadReport:      "LoadReport",
 }
 
 func (k ResponseKind) String() string {
@@ -67,7 +72,20 @@ func WriteResponse(w io.Writer, resp *Response) error {
 		log.Errorf(err.Error())
 		return err
 	}
-	// TODO: n
-	_, err = w.Write(data)
-	return err
+	return WriteFrame(w, data)
+}
+
+// ReadRequest read a request frame, the connection should be closed when
+// error returned
+func ReadRequest(r io.Reader) (*Request, error) {
+	data, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{}
+	if _, err := req.UnmarshalMsg(data); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
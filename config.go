@@ -21,8 +21,6 @@
 package starx
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
 	"os"
 	"path"
@@ -59,6 +57,20 @@ var (
 		die               chan bool                   // wait for end application
 
 		checkOrigin func(*http.Request) bool // check origin when websocket enabled
+
+		duplicatePolicy     DuplicateLoginPolicy // policy when uid bound by more than one session
+		duplicateKickReason string               // reason sent to the old session when kicked
+
+		groupReapInterval time.Duration // interval of reaping expired named groups
+
+		overflowPolicy  OverflowPolicy // default send buffer overflow policy of frontend sessions
+		overflowTimeout time.Duration  // default block timeout of OverflowBlock policy
+
+		masterHeartbeatInterval time.Duration // interval of heartbeat to master server in cluster mode
+
+		discovery cluster.Discovery // source of cluster topology
+
+		loadReportInterval time.Duration // interval of reporting load in backend server
 	}{}
 )
 
@@ -77,6 +89,13 @@ func init() {
 	// environment initialize
 	env.settings = make(map[string][]ServerInitFunc)
 	env.die = make(chan bool)
+	env.duplicatePolicy = DuplicateAllowMultiple
+	env.duplicateKickReason = "duplicate login"
+	env.groupReapInterval = time.Minute
+	env.overflowPolicy = OverflowBlock
+	env.overflowTimeout = 0
+	env.masterHeartbeatInterval = 5 * time.Second
+	env.loadReportInterval = 5 * time.Second
 
 	if wd, err := os.Getwd(); err != nil {
 		panic(err)
@@ -93,37 +112,52 @@ func init() {
 }
 
 func loadServers() {
-	// initialize servers config
-	if !fileExists(env.serversConfigPath) {
-		log.Fatalf("%s not found", env.serversConfigPath)
-		return
-	}
+	// servers.json is used as the default discovery, and the seed of master
+	// discovery in cluster mode
+	if env.discovery == nil {
+		if !fileExists(env.serversConfigPath) {
+			log.Fatalf("%s not found", env.serversConfigPath)
+			return
+		}
 
-	// read config file
-	f, _ := os.Open(env.serversConfigPath)
-	defer f.Close()
-
-	// load config from file
-	reader := json.NewDecoder(f)
-	var servers map[string][]*cluster.ServerConfig
-	for {
-		if err := reader.Decode(&servers); err == io.EOF {
-			break
-		} else if err != nil {
-			log.Errorf(err.Error())
+		file := cluster.NewFileDiscovery(env.serversConfigPath)
+		if app.standalone {
+			env.discovery = file
+		} else {
+			env.discovery = &masterDiscovery{seed: file}
 		}
 	}
 
+	servers, err := env.discovery.List()
+	if err != nil {
+		log.Fatalf("list servers failed(%s)", err.Error())
+		return
+	}
+
 	// register server to cluster
-	for typ, svrs := range servers {
-		for _, svr := range svrs {
-			svr.Type = typ
-			cluster.Register(svr)
-		}
+	for _, svr := range servers {
+		cluster.Register(svr)
 	}
 	cluster.DumpServers()
 }
 
+// reload servers when discovery supports reloading, e.g. servers.json in
+// standalone mode, or membership of master server in cluster mode, watchers
+// will be notified when servers changed
+func reloadServers() {
+	r, ok := env.discovery.(interface {
+		Reload() error
+	})
+	if !ok {
+		log.Infof("discovery %T does not support reloading, ignored", env.discovery)
+		return
+	}
+
+	if err := r.Reload(); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
 func initSetting() {
 	// init
 	if app.standalone {
@@ -168,6 +202,14 @@ func initSetting() {
 }
 
 func initServer() {
+	// reap expired named groups
+	timer.Register(env.groupReapInterval, reapGroups)
+
+	// report load to other servers in backend server
+	if !app.config.IsFrontend {
+		timer.Register(env.loadReportInterval, reportLoad)
+	}
+
 	setting, ok := env.settings[app.config.Type]
 	if !ok {
 		return
@@ -0,0 +1,295 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
+	"github.com/lonnng/starx/log"
+	"github.com/lonnng/starx/session"
+)
+
+const (
+	sessionBoundRoute   = "__Session.Bound"
+	sessionUnboundRoute = "__Session.Unbound"
+	uidMessageRoute     = "__Session.UIDMessage"
+)
+
+var ErrUserOffline = errors.New("user offline")
+
+// directory represents the cluster-wide uid directory in backend server, which
+// records frontend sessions bound to uid, all frontend servers notify binding
+// changes to every backend server
+var directory = newUIDDirectory()
+
+type uidBinding struct {
+	Uid    int64
+	Server string // frontend server id
+}
+
+// uidMessage represents a push or kick to all sessions of the uid, which
+// forwarded from frontend server to backend server
+type uidMessage struct {
+	Uid    int64
+	Route  string
+	Data   []byte
+	Kick   bool
+	Reason string
+	Except string // frontend server which has delivered message to its sessions
+}
+
+type directoryEntry struct {
+	acceptor *acceptor
+	sid      int64  // frontend session id
+	server   string // frontend server id
+}
+
+type uidDirectory struct {
+	sync.RWMutex
+	entries map[int64][]*directoryEntry
+}
+
+func newUIDDirectory() *uidDirectory {
+	return &uidDirectory{entries: make(map[int64][]*directoryEntry)}
+}
+
+// bindings are identified by frontend server and session, because binding may
+// be replayed after frontend server reconnected
+func (d *uidDirectory) bind(a *acceptor, sid int64, b *uidBinding) {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, e := range d.entries[b.Uid] {
+		if e.server == b.Server && e.sid == sid {
+			e.acceptor = a
+			return
+		}
+	}
+
+	d.entries[b.Uid] = append(d.entries[b.Uid], &directoryEntry{
+		acceptor: a,
+		sid:      sid,
+		server:   b.Server,
+	})
+}
+
+func (d *uidDirectory) unbind(a *acceptor, sid int64, b *uidBinding) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.removeLocked(b.Uid, func(e *directoryEntry) bool {
+		return e.server == b.Server && e.sid == sid
+	})
+}
+
+// remove all entries recorded by the acceptor, used when frontend server
+// disconnected
+func (d *uidDirectory) removeAcceptor(a *acceptor) {
+	d.Lock()
+	defer d.Unlock()
+
+	for uid := range d.entries {
+		d.removeLocked(uid, func(e *directoryEntry) bool {
+			return e.acceptor == a
+		})
+	}
+}
+
+func (d *uidDirectory) removeLocked(uid int64, match func(*directoryEntry) bool) {
+	var remain []*directoryEntry
+	for _, e := range d.entries[uid] {
+		if !match(e) {
+			remain = append(remain, e)
+		}
+	}
+
+	if len(remain) == 0 {
+		delete(d.entries, uid)
+	} else {
+		d.entries[uid] = remain
+	}
+}
+
+func (d *uidDirectory) lookup(uid int64) []*directoryEntry {
+	d.RLock()
+	defer d.RUnlock()
+
+	entries := make([]*directoryEntry, len(d.entries[uid]))
+	copy(entries, d.entries[uid])
+	return entries
+}
+
+// deliver push or kick message to all frontend sessions of the uid, returns
+// ErrUserOffline when the uid is not found in directory
+func (d *uidDirectory) deliver(m *uidMessage) error {
+	entries := d.lookup(m.Uid)
+	if len(entries) == 0 {
+		return ErrUserOffline
+	}
+
+	for _, e := range entries {
+		if m.Except != "" && e.server == m.Except {
+			continue
+		}
+
+		resp := &rpc.Response{Sid: e.sid}
+		if m.Kick {
+			resp.Kind = rpc.RemoteKick
+			resp.Data = []byte(m.Reason)
+		} else {
+			resp.Kind = rpc.RemotePush
+			resp.Route = m.Route
+			resp.Data = m.Data
+		}
+
+		if err := rpc.WriteResponse(e.acceptor.socket, resp); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	return nil
+}
+
+// notify all backend servers the binding uid of frontend session changed
+func notifyBinding(s *session.Session, route string, uid int64) {
+	data, err := encodeDirectory(&uidBinding{Uid: uid, Server: app.config.Id})
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	for _, id := range cluster.Backends() {
+		client, err := cluster.SessionClient(id, s.ID)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		notify(client, route, s.ID, data)
+	}
+}
+
+// replay the bindings(session id -> uid) to the backend server
+func replayBindings(svrId string, bindings map[int64]int64) {
+	for sid, uid := range bindings {
+		data, err := encodeDirectory(&uidBinding{Uid: uid, Server: app.config.Id})
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+
+		client, err := cluster.SessionClient(svrId, sid)
+		if err != nil {
+			log.Errorf(err.Error())
+			return
+		}
+		if err := notify(client, sessionBoundRoute, sid, data); err != nil {
+			log.Errorf(err.Error())
+			return
+		}
+	}
+}
+
+// forward uid message to a backend server, which delivers the message to the
+// sessions in other frontend servers
+func forwardUIDMessage(m *uidMessage) error {
+	data, err := encodeDirectory(m)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range cluster.Backends() {
+		client, err := cluster.Client(id)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		if err := notify(client, uidMessageRoute, 0, data); err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		return nil
+	}
+	return ErrUserOffline
+}
+
+// encode directory request data, which is decoded by gobDecode, gobEncode is
+// used by user rpc arguments only
+func encodeDirectory(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte(nil))
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// notify send a sys request which does not need reply, route format: "Service.Method"
+func notify(client *rpc.Client, route string, sid int64, data []byte) error {
+	r := strings.SplitN(route, ".", 2)
+	return client.Call(rpc.Sys, r[0], r[1], sid, nil, data)
+}
+
+func sendUIDMessage(m *uidMessage) error {
+	// backend server deliver via uid directory
+	if !app.config.IsFrontend {
+		return directory.deliver(m)
+	}
+
+	local := transporter.sessionsByUID(m.Uid)
+	for _, s := range local {
+		if m.Kick {
+			transporter.Kick(s, m.Reason)
+		} else {
+			transporter.push(s, m.Route, m.Data)
+		}
+	}
+
+	m.Except = app.config.Id
+	if err := forwardUIDMessage(m); err != nil && len(local) == 0 {
+		return err
+	}
+	return nil
+}
+
+// PushToUID push message to all sessions bound to the uid in the cluster,
+// which can be called in any server
+func PushToUID(uid int64, route string, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Type=PushToUID, UID=%d, Route=%s, Data=%+v", uid, route, v)
+
+	return sendUIDMessage(&uidMessage{Uid: uid, Route: route, Data: data})
+}
+
+// KickUID kick all sessions bound to the uid in the cluster with reason, which
+// can be called in any server
+func KickUID(uid int64, reason string) error {
+	log.Debugf("Type=KickUID, UID=%d, Reason=%s", uid, reason)
+
+	return sendUIDMessage(&uidMessage{Uid: uid, Kick: true, Reason: reason})
+}
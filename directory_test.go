@@ -0,0 +1,193 @@
+package starx
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
+	"github.com/lonnng/starx/route"
+	"github.com/lonnng/starx/session"
+)
+
+func TestUIDDirectory_Deliver(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	d := newUIDDirectory()
+	a := newAcceptor(1, c1)
+	d.bind(a, 10, &uidBinding{Uid: 100, Server: "gate-1"})
+	d.bind(a, 11, &uidBinding{Uid: 100, Server: "gate-2"})
+
+	if err := d.deliver(&uidMessage{Uid: 200}); err != ErrUserOffline {
+		t.Fatalf("expect user offline, got %v", err)
+	}
+
+	go d.deliver(&uidMessage{Uid: 100, Route: "onMail", Data: []byte("hello"), Except: "gate-1"})
+
+	resp, err := rpc.ReadResponse(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Kind != rpc.RemotePush || resp.Sid != 11 || resp.Route != "onMail" || string(resp.Data) != "hello" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	// replayed binding is not duplicated
+	d.bind(a, 10, &uidBinding{Uid: 100, Server: "gate-1"})
+	if len(d.lookup(100)) != 2 {
+		t.Fail()
+	}
+
+	d.unbind(a, 11, &uidBinding{Uid: 100, Server: "gate-2"})
+	if len(d.lookup(100)) != 1 {
+		t.Fail()
+	}
+
+	d.removeAcceptor(a)
+	if len(d.lookup(100)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestTransportService_BackendConnected(t *testing.T) {
+	app.config.IsFrontend = true
+	defer func() { app.config.IsFrontend = false }()
+	cluster.SetAppConfig(app.config)
+	defer cluster.SetAppConfig(nil)
+	cluster.SetSessionManager(transporter)
+
+	s := session.New(&mockEntity{})
+	if err := transporter.bind(s, 300); err != nil {
+		t.Fatal(err)
+	}
+	defer transporter.closeSession(s)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	requests := make(chan *rpc.Request, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			rr, err := rpc.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			requests <- rr
+		}
+	}()
+
+	// backend server connected after the session bound
+	cluster.Register(&cluster.ServerConfig{Type: "backend", Id: "backend-1", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port})
+	defer cluster.RemoveServer("backend-1")
+	if _, err := cluster.Client("backend-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// current server id reported first
+	select {
+	case rr := <-requests:
+		if rr.ServiceMethod != serverIdentifyRoute || string(rr.Data) != app.config.Id {
+			t.Fatalf("unexpected request: %+v", rr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server id not reported")
+	}
+
+	select {
+	case rr := <-requests:
+		b := &uidBinding{}
+		if err := gobDecode(b, rr.Data); err != nil {
+			t.Fatal(err)
+		}
+		if rr.ServiceMethod != sessionBoundRoute || rr.Sid != s.ID || b.Uid != 300 || b.Server != app.config.Id {
+			t.Fatalf("unexpected binding: %+v, %+v", rr, b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bindings not replayed")
+	}
+}
+
+// binding notification pins the session to connection, session state should
+// still be pushed before the first request
+func TestTransportService_BindThenCall(t *testing.T) {
+	app.config.IsFrontend = true
+	defer func() { app.config.IsFrontend = false }()
+	cluster.SetAppConfig(app.config)
+	defer cluster.SetAppConfig(nil)
+	cluster.SetSessionManager(transporter)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	requests := make(chan *rpc.Request, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			rr, err := rpc.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			requests <- rr
+		}
+	}()
+
+	cluster.Register(&cluster.ServerConfig{Type: "room", Id: "room-1", Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port})
+	defer cluster.RemoveServer("room-1")
+	if _, err := cluster.Client("room-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	a := newAgent(c1)
+	if err := transporter.bind(a.session, 400); err != nil {
+		t.Fatal(err)
+	}
+	defer transporter.closeSession(a.session)
+
+	// the backend never replies
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cluster.CallContext(ctx, rpc.User, route.NewRoute("room", "Room", "Join"), a.session, nil)
+
+	var routes []string
+	for len(routes) < 4 {
+		select {
+		case rr := <-requests:
+			routes = append(routes, rr.ServiceMethod)
+			if rr.ServiceMethod != sessionSyncRoute {
+				continue
+			}
+			s := session.New(&mockEntity{})
+			if err := s.ApplySyncData(rr.Data); err != nil {
+				t.Fatal(err)
+			}
+			if s.Uid != 400 {
+				t.Fatalf("expect uid 400, got %d", s.Uid)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("unexpected requests: %v", routes)
+		}
+	}
+	if routes[0] != serverIdentifyRoute || routes[1] != sessionBoundRoute ||
+		routes[2] != sessionSyncRoute || routes[3] != "Room.Join" {
+		t.Fatalf("unexpected requests: %v", routes)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"net/http"
 
 	"github.com/lonnng/starx"
@@ -42,10 +43,14 @@ func (r *Room) Message(s *session.Session, msg *UserMessage) error {
 }
 
 func main() {
+	// gate servers accept client connections, all rooms live in chat server
+	serverID := flag.String("server", "gate-1", "server id in configs/servers.json")
+	flag.Parse()
+
 	starx.SetServersConfig("configs/servers.json")
 	starx.Register(NewRoom())
 
-	starx.SetServerID("demo-server-1")
+	starx.SetServerID(*serverID)
 	starx.SetSerializer(json.NewSerializer())
 
 	log.SetLevel(log.LevelDebug)
@@ -24,9 +24,11 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lonnng/starx/log"
 	"github.com/lonnng/starx/session"
+	"github.com/lonnng/starx/timer"
 )
 
 const (
@@ -37,41 +39,174 @@ const (
 type SessionFilter func(*session.Session) bool
 
 var (
-	ErrCloseClosedGroup = errors.New("close closed group")
-	ErrClosedGroup      = errors.New("group closed")
-	ErrMemberNotFound   = errors.New("member not found in the group")
+	ErrCloseClosedGroup   = errors.New("close closed group")
+	ErrClosedGroup        = errors.New("group closed")
+	ErrMemberNotFound     = errors.New("member not found in the group")
+	ErrSessionDuplication = errors.New("session has existed in the current group")
 )
 
 // Group represents a session group which used to manage a number of
 // sessions, data send to the group will send to all session in it.
+// Members are keyed by session id, so unbound sessions and multiple sessions
+// of the same uid can join the group at the same time.
 type Group struct {
 	sync.RWMutex
-	status  int32
-	name    string                     // channel name
-	uids    map[int64]*session.Session // uid map to session pointer
-	members []int64                    // all user ids
+	status   int32
+	name     string                           // group name
+	sessions map[int64]*session.Session       // session id map to session pointer
+	members  []int64                          // all session ids, in join order
+	metadata map[int64]map[string]interface{} // session id map to member metadata
+	onLeave  LeaveFunc                        // called when member left
+	history  *groupHistory                    // latest broadcast messages, nil represents disabled
+	timers   []*timer.Timer                   // timers attached to the group
+	timerMu  sync.Mutex                       // serialize timer functions
+
+	registered   bool          // registered by GroupByName
+	createdAt    time.Time     // created time
+	lastActive   int64         // last active unix nano time stamp
+	ttl          time.Duration // group will be closed after ttl since created
+	idleTimeout  time.Duration // group will be closed when idle for idle timeout
+	emptyTimeout time.Duration // group will be closed when empty for empty timeout
 }
 
 func NewGroup(n string) *Group {
+	now := time.Now()
 	return &Group{
-		status: groupStatusWorking,
-		name:   n,
-		uids:   make(map[int64]*session.Session),
+		status:     groupStatusWorking,
+		name:       n,
+		sessions:   make(map[int64]*session.Session),
+		metadata:   make(map[int64]map[string]interface{}),
+		createdAt:  now,
+		lastActive: now.UnixNano(),
 	}
 }
 
+// SetTTL set the lifetime of the group, named group will be closed by reaper
+// after ttl since created, zero represents never expired
+func (c *Group) SetTTL(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ttl = d
+}
+
+// SetIdleTimeout set the idle timeout of the group, named group will be
+// closed by reaper when no member joined or no message sent for the duration,
+// zero represents never expired
+func (c *Group) SetIdleTimeout(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.idleTimeout = d
+}
+
+// SetEmptyTimeout set the empty timeout of the group, named group will be
+// closed by reaper when no member in it and no message sent for the duration,
+// zero represents never expired
+func (c *Group) SetEmptyTimeout(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.emptyTimeout = d
+}
+
+func (c *Group) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *Group) lastActiveTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive))
+}
+
+// expired returns whether the group should be reaped
+func (c *Group) expired(now time.Time) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	idle := now.Sub(c.lastActiveTime())
+	switch {
+	case c.ttl > 0 && now.Sub(c.createdAt) >= c.ttl:
+		return true
+	case c.idleTimeout > 0 && idle >= c.idleTimeout:
+		return true
+	case c.emptyTimeout > 0 && len(c.sessions) == 0 && idle >= c.emptyTimeout:
+		return true
+	}
+	return false
+}
+
+// OnLeave set the callback which will be called when member left the group,
+// including closed sessions which removed automatically
+func (c *Group) OnLeave(cb LeaveFunc) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.onLeave = cb
+}
+
+// Name returns the group name
+func (c *Group) Name() string {
+	return c.name
+}
+
+// Member returns the first joined session which bound to the uid
 func (c *Group) Member(uid int64) *session.Session {
 	c.RLock()
 	defer c.RUnlock()
 
-	return c.uids[uid]
+	for _, sid := range c.members {
+		if s := c.sessions[sid]; s.Uid == uid {
+			return s
+		}
+	}
+	return nil
 }
 
+// MembersByUID returns all sessions in the group which bound to the uid
+func (c *Group) MembersByUID(uid int64) []*session.Session {
+	c.RLock()
+	defer c.RUnlock()
+
+	var sessions []*session.Session
+	for _, sid := range c.members {
+		if s := c.sessions[sid]; s.Uid == uid {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// Members returns uids of all members, a uid may appear more than once when
+// the user joined with multiple sessions, and unbound sessions appear as 0
 func (c *Group) Members() []int64 {
 	c.RLock()
 	defer c.RUnlock()
 
-	return c.members
+	uids := make([]int64, 0, len(c.members))
+	for _, sid := range c.members {
+		uids = append(uids, c.sessions[sid].Uid)
+	}
+	return uids
+}
+
+// Sessions returns all member sessions in join order
+func (c *Group) Sessions() []*session.Session {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.sessionsLocked(nil)
+}
+
+func (c *Group) sessionsLocked(filter SessionFilter) []*session.Session {
+	sessions := make([]*session.Session, 0, len(c.members))
+	for _, sid := range c.members {
+		s := c.sessions[sid]
+		if filter != nil && !filter(s) {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
 }
 
 // Push message to partial client, which filter return true
@@ -87,20 +222,26 @@ func (c *Group) Multicast(route string, v interface{}, filter SessionFilter) err
 
 	log.Debugf("Type=Multicast Route=%s, Data=%+v", route, v)
 
+	c.touch()
 	c.RLock()
-	defer c.RUnlock()
+	members := c.sessionsLocked(filter)
+	c.RUnlock()
 
-	for _, s := range c.uids {
-		if !filter(s) {
-			continue
-		}
-		err = transporter.push(s, route, data)
-		if err != nil {
-			log.Error(err.Error())
-		}
+	transporter.fanout(members, route, data)
+	return nil
+}
+
+// Push message to all client except the excluded sessions, which is usually
+// used to skip the sender
+func (c *Group) BroadcastExcept(route string, v interface{}, except ...*session.Session) error {
+	excluded := make(map[int64]bool, len(except))
+	for _, s := range except {
+		excluded[s.ID] = true
 	}
 
-	return nil
+	return c.Multicast(route, v, func(s *session.Session) bool {
+		return !excluded[s.ID]
+	})
 }
 
 // Push message to all client
@@ -116,66 +257,156 @@ func (c *Group) Broadcast(route string, v interface{}) error {
 
 	log.Debugf("Type=broadcast Route=%s, Data=%+v", route, v)
 
-	c.RLock()
-	defer c.RUnlock()
+	c.touch()
+	c.Lock()
+	members := c.sessionsLocked(nil)
+	if c.history != nil {
+		c.history.record(route, data)
+	}
+	c.Unlock()
 
-	for _, s := range c.uids {
-		err = transporter.push(s, route, data)
-		if err != nil {
-			log.Error(err.Error())
+	return transporter.fanout(members, route, data)
+}
+
+// Range calls fn for each member in join order, stop iteration when fn
+// returns false, fn can call other methods of the group
+func (c *Group) Range(fn func(s *session.Session) bool) {
+	for _, s := range c.Sessions() {
+		if !fn(s) {
+			return
 		}
 	}
+}
+
+// SetMemberData set the metadata of member, which will be removed when the
+// member left
+func (c *Group) SetMemberData(s *session.Session, key string, value interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.sessions[s.ID]; !ok {
+		return ErrMemberNotFound
+	}
 
-	return err
+	data, ok := c.metadata[s.ID]
+	if !ok {
+		data = make(map[string]interface{})
+		c.metadata[s.ID] = data
+	}
+	data[key] = value
+	return nil
 }
 
-func (c *Group) IsContain(uid int64) bool {
+// MemberData returns the metadata of member, nil will be returned when not
+// exists
+func (c *Group) MemberData(s *session.Session, key string) interface{} {
 	c.RLock()
 	defer c.RUnlock()
 
-	if _, ok := c.uids[uid]; ok {
-		return true
-	}
+	return c.metadata[s.ID][key]
+}
 
-	return false
+// IsContain returns whether any session bound to the uid in the group
+func (c *Group) IsContain(uid int64) bool {
+	return c.Member(uid) != nil
 }
 
+// Contains returns whether the session in the group
+func (c *Group) Contains(s *session.Session) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	_, ok := c.sessions[s.ID]
+	return ok
+}
+
+// Add session to the group, closed session can not be added
 func (c *Group) Add(session *session.Session) error {
 	if c.isClosed() {
 		return ErrClosedGroup
 	}
 
 	c.Lock()
-	defer c.Unlock()
+	if _, ok := c.sessions[session.ID]; ok {
+		c.Unlock()
+		return ErrSessionDuplication
+	}
+	c.sessions[session.ID] = session
+	c.members = append(c.members, session.ID)
+
+	// snapshot in lock, and replay without lock, so that a slow member does
+	// not block the group
+	var history []GroupMessage
+	if c.history != nil {
+		history = append(history, c.history.recent(time.Now())...)
+	}
+	c.Unlock()
+
+	// closed session will never be removed by close hook
+	if !joinAliveContainer(session, c) {
+		c.Lock()
+		c.removeLocked(session)
+		c.Unlock()
+		return ErrSessionClosed
+	}
 
-	c.uids[session.Uid] = session
-	c.members = append(c.members, session.Uid)
+	replay(session, history)
 
+	c.touch()
 	return nil
 }
 
+// Leave remove all sessions bound to the uid from the group
 func (c *Group) Leave(uid int64) error {
 	if c.isClosed() {
 		return ErrClosedGroup
 	}
 
-	if !c.IsContain(uid) {
-		return ErrMemberNotFound
+	c.Lock()
+	var left []*session.Session
+	for _, sid := range c.members {
+		if s := c.sessions[sid]; s.Uid == uid {
+			left = append(left, s)
+		}
 	}
+	for _, s := range left {
+		c.removeLocked(s)
+	}
+	cb := c.onLeave
+	c.Unlock()
 
-	c.Lock()
-	defer c.Unlock()
+	if len(left) == 0 {
+		return ErrMemberNotFound
+	}
 
-	var temp []int64
-	for i, u := range c.members {
-		if u == uid {
-			temp = append(temp, c.members[:i]...)
-			c.members = append(temp, c.members[(i+1):]...)
-			break
+	for _, s := range left {
+		leaveContainer(s, c)
+		if cb != nil {
+			cb(s, LeaveReasonLeave)
 		}
 	}
-	delete(c.uids, uid)
+	return nil
+}
 
+// LeaveSession remove the session from the group
+func (c *Group) LeaveSession(s *session.Session) error {
+	if c.isClosed() {
+		return ErrClosedGroup
+	}
+
+	c.Lock()
+	if _, ok := c.sessions[s.ID]; !ok {
+		c.Unlock()
+		return ErrMemberNotFound
+	}
+	c.removeLocked(s)
+	cb := c.onLeave
+	c.Unlock()
+
+	leaveContainer(s, c)
+	if cb != nil {
+		cb(s, LeaveReasonLeave)
+	}
 	return nil
 }
 
@@ -184,13 +415,53 @@ func (c *Group) LeaveAll() error {
 		return ErrClosedGroup
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	c.leaveAll()
+	return nil
+}
 
-	c.uids = make(map[int64]*session.Session)
+// remove all members, leave callback will be called for every member
+func (c *Group) leaveAll() {
+	c.Lock()
+	sessions := c.sessions
+	cb := c.onLeave
+	c.sessions = make(map[int64]*session.Session)
 	c.members = make([]int64, 0)
+	c.metadata = make(map[int64]map[string]interface{})
+	c.Unlock()
 
-	return nil
+	for _, s := range sessions {
+		leaveContainer(s, c)
+		if cb != nil {
+			cb(s, LeaveReasonClosed)
+		}
+	}
+}
+
+// remove closed session from the group, implement sessionContainer
+func (c *Group) removeClosed(s *session.Session) {
+	c.Lock()
+	if _, ok := c.sessions[s.ID]; !ok {
+		c.Unlock()
+		return
+	}
+	c.removeLocked(s)
+	cb := c.onLeave
+	c.Unlock()
+
+	if cb != nil {
+		cb(s, LeaveReasonSessionClosed)
+	}
+}
+
+func (c *Group) removeLocked(s *session.Session) {
+	for i, sid := range c.members {
+		if sid == s.ID {
+			c.members = append(c.members[:i], c.members[i+1:]...)
+			break
+		}
+	}
+	delete(c.sessions, s.ID)
+	delete(c.metadata, s.ID)
 }
 
 // Count get current member amount in the group
@@ -198,7 +469,7 @@ func (c *Group) Count() int {
 	c.RLock()
 	defer c.RUnlock()
 
-	return len(c.uids)
+	return len(c.sessions)
 }
 
 func (c *Group) isClosed() bool {
@@ -210,15 +481,22 @@ func (c *Group) isClosed() bool {
 
 // Close destroy group, which will release all resource in the group
 func (c *Group) Close() error {
-	if c.isClosed() {
+	// closed by another goroutine
+	if !atomic.CompareAndSwapInt32(&c.status, groupStatusWorking, groupStatusClosed) {
 		return ErrCloseClosedGroup
 	}
 
-	atomic.StoreInt32(&c.status, groupStatusClosed)
+	c.RLock()
+	registered := c.registered
+	c.RUnlock()
+
+	if registered {
+		unregisterGroup(c)
+	}
+	c.stopTimers()
 
 	// release all reference
-	c.uids = make(map[int64]*session.Session)
-	c.members = []int64{}
+	c.leaveAll()
 
 	return nil
 }
@@ -1,20 +1,27 @@
 package starx
 
 import (
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/session"
 )
 
-func TestChannel_Add(t *testing.T) {
+func TestGroup_Add(t *testing.T) {
 	c := NewGroup("test_add")
 
 	var paraCount = 100
 	w := make(chan bool, paraCount)
 	for i := 0; i < paraCount; i++ {
 		go func(id int) {
-			s := &session.Session{}
+			s := session.New(nil)
 			s.Bind(int64(id + 1))
 			c.Add(s)
 			w <- true
@@ -29,7 +36,7 @@ func TestChannel_Add(t *testing.T) {
 		t.Fail()
 	}
 
-	n := rand.Int63n(int64(paraCount) + 1)
+	n := rand.Int63n(int64(paraCount)) + 1
 	if !c.IsContain(n) {
 		t.Fail()
 	}
@@ -50,3 +57,366 @@ func TestChannel_Add(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGroup_Broadcast(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	g := NewGroup("test_broadcast")
+
+	// two sessions from the same frontend server, one local session
+	a := newAcceptor(1, c1)
+	for i, fsid := range []int64{10, 11} {
+		s := a.Session(fsid)
+		s.Uid = int64(i + 1)
+		g.Add(s)
+	}
+	m := &mockEntity{}
+	local := session.New(m)
+	local.Uid = 3
+	g.Add(local)
+
+	go g.Broadcast("onMessage", []byte("hello"))
+
+	resp, err := rpc.ReadResponse(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Kind != rpc.RemotePush || resp.Route != "onMessage" || len(resp.Sids) != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if !(resp.Sids[0] == 10 && resp.Sids[1] == 11) && !(resp.Sids[0] == 11 && resp.Sids[1] == 10) {
+		t.Fatalf("unexpected sids: %v", resp.Sids)
+	}
+	if m.sent != 1 {
+		t.Fail()
+	}
+}
+
+func TestGroup_BroadcastPooled(t *testing.T) {
+	g := NewGroup("test_broadcast_pooled")
+
+	// two connections from the same frontend server
+	responses := make(chan *rpc.Response, 2)
+	for i, fsid := range []int64{10, 11} {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+
+		a := newAcceptor(int64(i+1), c1)
+		a.server.Store("gate-1")
+		g.Add(a.Session(fsid))
+
+		go func() {
+			if resp, err := rpc.ReadResponse(c2); err == nil {
+				responses <- resp
+			}
+		}()
+	}
+
+	go g.Broadcast("onMessage", []byte("hello"))
+
+	resp := <-responses
+	if resp.Kind != rpc.RemotePush || len(resp.Sids) != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	select {
+	case resp := <-responses:
+		t.Fatalf("message sent to frontend server twice: %+v", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// sessions created by acceptor while broadcasting to its sessions
+func TestGroup_BroadcastConcurrentSessions(t *testing.T) {
+	g := NewGroup("test_broadcast_concurrent")
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2)
+
+	a := newAcceptor(1, c1)
+	g.Add(a.Session(1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(2); i < 100; i++ {
+			a.Session(i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		g.Broadcast("onMessage", []byte("hello"))
+	}
+	<-done
+}
+
+func newBenchmarkGroup(n int) *Group {
+	g := NewGroup("benchmark")
+	for i := 0; i < n; i++ {
+		s := session.New(&mockEntity{})
+		s.Uid = int64(i + 1)
+		g.Add(s)
+	}
+	return g
+}
+
+func benchmarkGroupBroadcast(b *testing.B, n int) {
+	g := newBenchmarkGroup(n)
+	msg := []byte("hello world")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Broadcast("onMessage", msg)
+	}
+	b.ReportAllocs()
+}
+
+// push to each member, which encode packet for every session
+func benchmarkGroupPushEach(b *testing.B, n int) {
+	g := newBenchmarkGroup(n)
+	msg := []byte("hello world")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range g.Sessions() {
+			transporter.push(s, "onMessage", msg)
+		}
+	}
+	b.ReportAllocs()
+}
+
+func BenchmarkGroup_Broadcast1k(b *testing.B)  { benchmarkGroupBroadcast(b, 1000) }
+func BenchmarkGroup_Broadcast10k(b *testing.B) { benchmarkGroupBroadcast(b, 10000) }
+func BenchmarkGroup_PushEach1k(b *testing.B)   { benchmarkGroupPushEach(b, 1000) }
+func BenchmarkGroup_PushEach10k(b *testing.B)  { benchmarkGroupPushEach(b, 10000) }
+
+func TestGroup_SessionKey(t *testing.T) {
+	g := NewGroup("test_session_key")
+
+	// anonymous sessions
+	a1, a2 := session.New(&mockEntity{}), session.New(&mockEntity{})
+	// the same user with two devices
+	d1, d2 := session.New(&mockEntity{}), session.New(&mockEntity{})
+	d1.Uid, d2.Uid = 100, 100
+
+	for _, s := range []*session.Session{a1, a2, d1, d2} {
+		if err := g.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Add(a1); err != ErrSessionDuplication {
+		t.Fatalf("expect duplication error, got %v", err)
+	}
+
+	if g.Count() != 4 || len(g.MembersByUID(0)) != 2 || len(g.MembersByUID(100)) != 2 {
+		t.Fatalf("unexpected members: %v", g.Members())
+	}
+	if g.Member(100) != d1 {
+		t.Fail()
+	}
+
+	g.LeaveSession(a1)
+	if g.Contains(a1) || !g.Contains(a2) {
+		t.Fail()
+	}
+
+	g.Leave(100)
+	if g.IsContain(100) || g.Count() != 1 {
+		t.Fail()
+	}
+}
+
+func TestGroup_SessionClosed(t *testing.T) {
+	g := NewGroup("test_session_closed")
+	c := newChannel("test_session_closed")
+
+	var reasons []LeaveReason
+	g.OnLeave(func(s *session.Session, reason LeaveReason) {
+		reasons = append(reasons, reason)
+	})
+
+	s1, s2 := session.New(&mockEntity{}), session.New(&mockEntity{})
+	s1.Uid, s2.Uid = 1, 2
+	g.Add(s1)
+	g.Add(s2)
+	c.Add(s1)
+
+	// uid changed after joined
+	s1.Uid = 10
+	transporter.closeSession(s1)
+
+	if g.IsContain(1) || c.IsContain(1) || g.Count() != 1 {
+		t.Fatal("closed session should be removed")
+	}
+
+	g.Leave(2)
+	if len(reasons) != 2 || reasons[0] != LeaveReasonSessionClosed || reasons[1] != LeaveReasonLeave {
+		t.Fatalf("unexpected leave reasons: %v", reasons)
+	}
+
+	if _, ok := memberships.joined[s1]; ok {
+		t.Fail()
+	}
+	if _, ok := memberships.joined[s2]; ok {
+		t.Fail()
+	}
+
+	// closed session can not be added
+	if err := g.Add(s1); err != ErrSessionClosed {
+		t.Fatalf("expect session closed, got %v", err)
+	}
+	if g.Count() != 0 || g.Contains(s1) {
+		t.Fatal("closed session should not be added")
+	}
+	if _, ok := memberships.joined[s1]; ok {
+		t.Fail()
+	}
+}
+
+func TestGroup_ConcurrentClose(t *testing.T) {
+	g := GroupByName("test_concurrent_close")
+	for i := 0; i < 100; i++ {
+		g.Add(session.New(&mockEntity{}))
+	}
+
+	var left int32
+	g.OnLeave(func(s *session.Session, reason LeaveReason) {
+		atomic.AddInt32(&left, 1)
+	})
+
+	var closed int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if g.Close() == nil {
+				atomic.AddInt32(&closed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if closed != 1 || left != 100 {
+		t.Fatalf("group should be closed once, closed=%d, left=%d", closed, left)
+	}
+}
+
+func TestGroup_History(t *testing.T) {
+	g := NewGroup("test_history")
+	g.SetHistory(2, 20*time.Millisecond)
+
+	for _, msg := range []string{"1", "2", "3"} {
+		g.Broadcast("onMessage", []byte(msg))
+	}
+
+	history := g.History()
+	if len(history) != 2 || string(history[0].Data) != "2" || string(history[1].Data) != "3" {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	m := &mockEntity{}
+	g.Add(session.New(m))
+	if m.sent != 2 {
+		t.Fatalf("expect 2 messages replayed, got %d", m.sent)
+	}
+
+	// messages replayed to backend session are forwarded to frontend server
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go g.Add(newAcceptor(1, c1).Session(10))
+	for _, msg := range []string{"2", "3"} {
+		resp, err := rpc.ReadResponse(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Kind != rpc.RemotePush || string(resp.Data) != msg || len(resp.Sids) != 1 || resp.Sids[0] != 10 {
+			t.Fatalf("unexpected response: %+v", resp)
+		}
+	}
+
+	// expired messages will not be replayed
+	time.Sleep(30 * time.Millisecond)
+	m = &mockEntity{}
+	g.Add(session.New(m))
+	if m.sent != 0 || len(g.History()) != 0 {
+		t.Fail()
+	}
+}
+
+// history longer than send buffer should not be dropped
+func TestGroup_HistoryOverBuffer(t *testing.T) {
+	g := NewGroup("test_history_over_buffer")
+	n := packetBufferSize * 2
+	g.SetHistory(n, 0)
+	for i := 0; i < n; i++ {
+		g.Broadcast("onMessage", []byte("hello"))
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	a := newAgent(c1)
+	a.setOverflowPolicy(OverflowBlock, 0)
+
+	done := make(chan error, 1)
+	go func() { done <- g.Add(a.session) }()
+
+	// client starts reading after the send buffer filled
+	time.Sleep(20 * time.Millisecond)
+	received := 0
+	for received < n {
+		select {
+		case <-a.sendBuffer:
+			received++
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("expect %d messages replayed, got %d", n, received)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroup_Metadata(t *testing.T) {
+	g := NewGroup("test_metadata")
+
+	m1, m2, m3 := &mockEntity{}, &mockEntity{}, &mockEntity{}
+	s1, s2, s3 := session.New(m1), session.New(m2), session.New(m3)
+	for _, s := range []*session.Session{s1, s2, s3} {
+		g.Add(s)
+	}
+
+	g.SetMemberData(s1, "seat", 1)
+	if g.MemberData(s1, "seat") != 1 || g.MemberData(s2, "seat") != nil {
+		t.Fail()
+	}
+	if err := g.SetMemberData(session.New(nil), "seat", 2); err != ErrMemberNotFound {
+		t.Fail()
+	}
+
+	g.BroadcastExcept("onMessage", []byte("hello"), s1)
+	if m1.sent != 0 || m2.sent != 1 || m3.sent != 1 {
+		t.Fatal("excluded session should not receive message")
+	}
+
+	var order []*session.Session
+	g.Range(func(s *session.Session) bool {
+		order = append(order, s)
+		return len(order) < 2
+	})
+	if len(order) != 2 || order[0] != s1 || order[1] != s2 {
+		t.Fatal("unexpected iteration order")
+	}
+
+	g.LeaveSession(s1)
+	if g.MemberData(s1, "seat") != nil {
+		t.Fail()
+	}
+}
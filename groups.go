@@ -0,0 +1,109 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lonnng/starx/log"
+)
+
+// GroupStat represents the statistics of a named group, used for admin tools
+type GroupStat struct {
+	Name       string
+	Count      int       // member amount
+	CreatedAt  time.Time // the time group created
+	LastActive time.Time // the last time member joined or message sent
+}
+
+// groups represents all named groups in current server
+var groups = &struct {
+	sync.RWMutex
+	named map[string]*Group
+}{named: make(map[string]*Group)}
+
+// GroupByName returns the group with the name, a new group will be created
+// and registered if not exists, registered groups are kept until closed,
+// unless ttl, idle timeout or empty timeout set on the group
+func GroupByName(name string) *Group {
+	groups.Lock()
+	defer groups.Unlock()
+
+	if g, ok := groups.named[name]; ok && !g.isClosed() {
+		return g
+	}
+
+	g := NewGroup(name)
+	g.Lock()
+	g.registered = true
+	g.Unlock()
+	groups.named[name] = g
+	return g
+}
+
+// Groups returns the statistics of all named groups, sorted by name
+func Groups() []GroupStat {
+	groups.RLock()
+	defer groups.RUnlock()
+
+	stats := make([]GroupStat, 0, len(groups.named))
+	for name, g := range groups.named {
+		stats = append(stats, GroupStat{
+			Name:       name,
+			Count:      g.Count(),
+			CreatedAt:  g.createdAt,
+			LastActive: g.lastActiveTime(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// unregister closed group
+func unregisterGroup(g *Group) {
+	groups.Lock()
+	defer groups.Unlock()
+
+	if groups.named[g.name] == g {
+		delete(groups.named, g.name)
+	}
+}
+
+// reapGroups close all expired groups
+func reapGroups() {
+	now := time.Now()
+
+	groups.RLock()
+	var expired []*Group
+	for _, g := range groups.named {
+		if g.expired(now) {
+			expired = append(expired, g)
+		}
+	}
+	groups.RUnlock()
+
+	for _, g := range expired {
+		log.Debugf("Group expired, Name=%s, Count=%d", g.name, g.Count())
+		g.Close()
+	}
+}
@@ -0,0 +1,64 @@
+package starx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/session"
+)
+
+func TestGroupByName(t *testing.T) {
+	g := GroupByName("test_by_name")
+	if GroupByName("test_by_name") != g {
+		t.Fatal("expect the same group")
+	}
+
+	g.Add(session.New(&mockEntity{}))
+
+	var found bool
+	for _, stat := range Groups() {
+		if stat.Name == "test_by_name" {
+			found = true
+			if stat.Count != 1 {
+				t.Fail()
+			}
+		}
+	}
+	if !found {
+		t.Fatal("group not registered")
+	}
+
+	g.Close()
+	if GroupByName("test_by_name") == g {
+		t.Fatal("closed group should be replaced")
+	}
+	GroupByName("test_by_name").Close()
+}
+
+func TestReapGroups(t *testing.T) {
+	empty := GroupByName("test_reap_empty")
+	reaped := GroupByName("test_reap_empty_timeout")
+	ttl := GroupByName("test_reap_ttl")
+	idle := GroupByName("test_reap_idle")
+	alive := GroupByName("test_reap_alive")
+	for _, g := range []*Group{ttl, idle, alive} {
+		g.Add(session.New(&mockEntity{}))
+	}
+	ttl.SetTTL(time.Millisecond)
+	idle.SetIdleTimeout(time.Millisecond)
+	reaped.SetEmptyTimeout(time.Millisecond)
+	alive.SetEmptyTimeout(time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+	reapGroups()
+
+	// empty groups are kept unless empty timeout set
+	if empty.isClosed() || !reaped.isClosed() || !ttl.isClosed() || !idle.isClosed() || alive.isClosed() {
+		t.Fatal("unexpected reaped groups")
+	}
+	if GroupByName("test_reap_empty") != empty {
+		t.Fail()
+	}
+	empty.Close()
+	alive.Close()
+}
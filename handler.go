@@ -25,6 +25,7 @@ import (
 	"errors"
 	"net"
 	"reflect"
+	"sync/atomic"
 
 	"github.com/lonnng/starx/cluster"
 	"github.com/lonnng/starx/cluster/rpc"
@@ -44,6 +45,12 @@ const (
 
 var handler = newHandlerService()
 
+// handshakeData represents the handshake request payload sent by client
+type handshakeData struct {
+	Sys  map[string]interface{} `json:"sys"`
+	User map[string]interface{} `json:"user"`
+}
+
 type handlerService struct {
 	serviceMap map[string]*component.Service
 }
@@ -87,6 +94,7 @@ func (hs *handlerService) handle(conn net.Conn) {
 	// register new session when new connection connected in
 	agent := transporter.createAgent(conn)
 	log.Debugf("New session established: %s", agent.String())
+	sessionCreated(agent.session)
 
 	// all user logic will be handled in single goroutine
 	// synchronized in below routine
@@ -97,13 +105,25 @@ func (hs *handlerService) handle(conn net.Conn) {
 				if ok && p != nil {
 					hs.processPacket(agent, p)
 				}
+			case fn := <-agent.tasks:
+				fn()
 			case m, ok := <-agent.sendBuffer:
-				if ok && m != nil {
-					_, err := agent.socket.Write(m)
-					if err != nil {
-						log.Error(err)
-						agent.Close()
-					}
+				if !ok {
+					break
+				}
+
+				// nil represents the agent should be closed after all pending
+				// packets written
+				if m == nil {
+					agent.close(agent.session.CloseReason())
+					break
+				}
+
+				n, err := agent.socket.Write(m)
+				atomic.AddInt64(&agent.conn.BytesOut, int64(n))
+				if err != nil {
+					log.Error(err)
+					agent.close(session.CloseReasonClientEOF)
 				}
 			case <-agent.die:
 				return
@@ -118,9 +138,10 @@ func (hs *handlerService) handle(conn net.Conn) {
 	buf := make([]byte, 2048)
 	for {
 		n, err := conn.Read(buf)
+		atomic.AddInt64(&agent.conn.BytesIn, int64(n))
 		if err != nil {
 			log.Errorf("Read message error: %s, session will be closed immediately", err.Error())
-			agent.Close()
+			agent.close(session.CloseReasonClientEOF)
 			break // break read packet loop
 		}
 		tmp = append(tmp, buf[:n]...)
@@ -130,7 +151,7 @@ func (hs *handlerService) handle(conn net.Conn) {
 		for len(tmp) >= packet.HeadLength {
 			p, tmp, err = packet.Unpack(tmp)
 			if err != nil {
-				agent.Close()
+				agent.close(session.CloseReasonProtocolError)
 				break
 			}
 
@@ -145,35 +166,11 @@ func (hs *handlerService) handle(conn net.Conn) {
 func (hs *handlerService) processPacket(a *agent, p *packet.Packet) {
 	switch p.Type {
 	case packet.Handshake:
-		a.status = statusHandshake
-		data, err := json.Marshal(map[string]interface{}{
-			"code": 200,
-			"sys":  map[string]float64{"heartbeat": env.heartbeatInternal.Seconds()},
-		})
-		if err != nil {
-			log.Infof(err.Error())
-		}
-
-		rp := &packet.Packet{
-			Type:   packet.Handshake,
-			Length: len(data),
-			Data:   data,
-		}
-
-		resp, err := rp.Pack()
-		if err != nil {
-			log.Errorf(err.Error())
-			a.Close()
-		}
-
-		if err := a.Send(resp); err != nil {
-			log.Errorf(err.Error())
-			a.Close()
-		}
-		log.Debugf("Session handshake Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
+		hs.handshake(a, p)
 	case packet.HandshakeAck:
-		a.status = statusWorking
+		a.setStatus(statusWorking)
 		log.Debugf("Receive handshake ACK Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
+		sessionHandshakeAck(a.session)
 	case packet.Data:
 		m, err := message.Decode(p.Data)
 		if err != nil {
@@ -186,8 +183,71 @@ func (hs *handlerService) processPacket(a *agent, p *packet.Packet) {
 		go a.heartbeat()
 	default:
 		log.Infof("invalid packet type")
-		a.Close()
+		a.close(session.CloseReasonProtocolError)
+	}
+}
+
+// Handshake with client, handshake callbacks registered by application will
+// be invoked, the agent will be closed after response if handshake rejected
+func (hs *handlerService) handshake(a *agent, p *packet.Packet) {
+	a.setStatus(statusHandshake)
+
+	hd := &handshakeData{}
+	if len(p.Data) > 0 {
+		if err := json.Unmarshal(p.Data, hd); err != nil {
+			log.Errorf(err.Error())
+			a.close(session.CloseReasonProtocolError)
+			return
+		}
+	}
+
+	// client information reported in handshake
+	if t, ok := hd.Sys["type"].(string); ok {
+		a.conn.ClientType = t
+	}
+	if v, ok := hd.Sys["version"].(string); ok {
+		a.conn.ClientVersion = v
+	}
+
+	code := 200
+	rejectErr := sessionHandshake(a.session, hd.Sys, hd.User)
+	if rejectErr != nil {
+		code = 500
+		log.Infof("Session handshake rejected, Id=%d, Reason=%s", a.id, rejectErr.Error())
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"code": code,
+		"sys":  map[string]float64{"heartbeat": env.heartbeatInternal.Seconds()},
+	})
+	if err != nil {
+		log.Infof(err.Error())
+	}
+
+	rp := &packet.Packet{
+		Type:   packet.Handshake,
+		Length: len(data),
+		Data:   data,
+	}
+
+	resp, err := rp.Pack()
+	if err != nil {
+		log.Errorf(err.Error())
+		a.close(session.CloseReasonProtocolError)
+		return
+	}
+
+	if err := a.Send(resp); err != nil {
+		log.Errorf(err.Error())
+		a.close(session.CloseReasonClientEOF)
+		return
+	}
+
+	if rejectErr != nil {
+		a.closeAfterFlush(session.CloseReasonHandshakeRejected)
+		return
 	}
+	log.Debugf("Session handshake Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
 }
 
 func (hs *handlerService) processMessage(session *session.Session, msg *message.Message) {
@@ -0,0 +1,123 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"time"
+
+	"github.com/lonnng/starx/log"
+	"github.com/lonnng/starx/session"
+)
+
+// GroupMessage represents a broadcast message recorded in group history
+type GroupMessage struct {
+	Route string    // push route
+	Data  []byte    // serialized payload
+	Time  time.Time // broadcast time
+}
+
+// groupHistory represents a bounded buffer of the latest broadcast messages,
+// which will be replayed to new members
+type groupHistory struct {
+	size     int           // max amount of messages
+	maxAge   time.Duration // messages older than max age will be discarded, zero represents forever
+	messages []GroupMessage
+}
+
+func (h *groupHistory) record(route string, data []byte) {
+	h.messages = append(h.messages, GroupMessage{Route: route, Data: data, Time: time.Now()})
+	if over := len(h.messages) - h.size; over > 0 {
+		h.messages = append(h.messages[:0], h.messages[over:]...)
+	}
+}
+
+// recent returns the messages not expired, expired messages will be discarded
+func (h *groupHistory) recent(now time.Time) []GroupMessage {
+	if h.maxAge > 0 {
+		i := 0
+		for i < len(h.messages) && now.Sub(h.messages[i].Time) > h.maxAge {
+			i++
+		}
+		if i > 0 {
+			h.messages = append(h.messages[:0], h.messages[i:]...)
+		}
+	}
+	return h.messages
+}
+
+// replay push history messages to the session in broadcast order, messages
+// to backend session are forwarded to its frontend server, messages to local
+// session are pushed one by one and never dropped, even though the history is
+// longer than the send buffer
+func replay(s *session.Session, messages []GroupMessage) {
+	members := []*session.Session{s}
+	for _, m := range messages {
+		var err error
+		if _, ok := s.Entity.(*acceptor); ok {
+			err = transporter.fanout(members, m.Route, m.Data)
+		} else {
+			err = transporter.push(s, m.Route, m.Data)
+		}
+		if err != nil {
+			log.Errorf(err.Error())
+			return
+		}
+	}
+}
+
+// SetHistory enable the group to record the latest size broadcast messages,
+// which will be replayed to new members when added, messages older than max
+// age will not be replayed, zero max age represents forever, zero size disable
+// the history, replaying applies the send buffer overflow policy of the new
+// member, so Add may block until the history queued
+func (c *Group) SetHistory(size int, maxAge time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if size <= 0 {
+		c.history = nil
+		return
+	}
+
+	if c.history == nil {
+		c.history = &groupHistory{}
+	}
+	c.history.size = size
+	c.history.maxAge = maxAge
+	if over := len(c.history.messages) - size; over > 0 {
+		c.history.messages = append(c.history.messages[:0], c.history.messages[over:]...)
+	}
+}
+
+// History returns the recorded broadcast messages which not expired
+func (c *Group) History() []GroupMessage {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.history == nil {
+		return nil
+	}
+
+	messages := c.history.recent(time.Now())
+	ret := make([]GroupMessage, len(messages))
+	copy(ret, messages)
+	return ret
+}
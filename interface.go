@@ -23,6 +23,7 @@ package starx
 import (
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/lonnng/starx/cluster"
@@ -35,7 +36,7 @@ func Run() {
 	// output welcome message
 	// welcomeMsg()
 
-	// load servers config from $env.serversConfigPath
+	// load servers config from discovery, $env.serversConfigPath by default
 	loadServers()
 
 	// init cluster servers config
@@ -63,6 +64,12 @@ func SetRouter(svrType string, fn func(*session.Session) string) {
 	cluster.Router(svrType, fn)
 }
 
+// SetSessionSyncKeys set the session data keys, which will be synchronized
+// between frontend session and backend sessions with the binding uid
+func SetSessionSyncKeys(keys ...string) {
+	session.SetSyncKeys(keys...)
+}
+
 func Register(c component.Component) {
 	comps = append(comps, c)
 }
@@ -94,6 +101,93 @@ func SetCheckOriginFunc(fn func(*http.Request) bool) {
 	env.checkOrigin = fn
 }
 
+// SetDuplicateLoginPolicy set the behavior when a bound uid is bound by another
+// session, reason will be sent to the kicked client when DuplicateKickOld used,
+// all sessions keep online by default
+func SetDuplicateLoginPolicy(policy DuplicateLoginPolicy, reason string) {
+	env.duplicatePolicy = policy
+	env.duplicateKickReason = reason
+}
+
+// SetGroupReapInterval set the interval of checking named groups, which are
+// closed when ttl, idle timeout or empty timeout of the group reached
+func SetGroupReapInterval(d time.Duration) {
+	env.groupReapInterval = d
+}
+
+// SetOverflowPolicy set the default behavior when send buffer of frontend
+// session is full, timeout only used by OverflowBlock, zero represents block
+// until the buffer available
+func SetOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	env.overflowPolicy = policy
+	env.overflowTimeout = timeout
+}
+
+// SetSessionOverflowPolicy set the send buffer overflow policy of the
+// frontend session, which overrides the default policy
+func SetSessionOverflowPolicy(s *session.Session, policy OverflowPolicy, timeout time.Duration) error {
+	a, ok := s.Entity.(*agent)
+	if !ok {
+		return ErrNotAgentSession
+	}
+
+	a.setOverflowPolicy(policy, timeout)
+	return nil
+}
+
+// DroppedMessages returns the total amount of messages dropped for send buffer
+// overflow in current server, the amount of each session can be retrieved by
+// session.ConnInfo
+func DroppedMessages() int64 {
+	return atomic.LoadInt64(&dropped)
+}
+
+// SetMasterHeartbeatInterval set the interval of heartbeat to master server
+// in cluster mode, server will be removed from cluster when master server has
+// not received heartbeat for three intervals
+func SetMasterHeartbeatInterval(d time.Duration) {
+	env.masterHeartbeatInterval = d
+}
+
+// SetBalancer set the strategy of selecting backend server of the type for
+// sessions, e.g. cluster.RoundRobin(), router set by SetRouter takes
+// precedence over balancer, a random server is selected when neither of
+// them set
+func SetBalancer(svrType string, b cluster.Balancer) {
+	cluster.SetBalancer(svrType, b)
+}
+
+// SetLoadReportInterval set the interval of backend server reporting its
+// load, which is used by cluster.LeastLoaded balancer
+func SetLoadReportInterval(d time.Duration) {
+	env.loadReportInterval = d
+}
+
+// SetCallTimeout set the default timeout of rpc calls to servers of the type,
+// empty type represents all types, zero represents waiting for reply forever,
+// calls with context deadline are not affected
+func SetCallTimeout(svrType string, d time.Duration) {
+	cluster.SetCallTimeout(svrType, d)
+}
+
+// SetRPCPoolSize set the amount of rpc connections to each backend server,
+// requests of the same session are always sent through the same connection
+func SetRPCPoolSize(n int) {
+	cluster.SetPoolSize(n)
+}
+
+// SetReconnectBackoff set the backoff of reconnecting backend server when
+// rpc connection broken, backoff doubles after every failure until max
+func SetReconnectBackoff(min, max time.Duration) {
+	cluster.SetReconnectBackoff(min, max)
+}
+
+// SetDiscovery set the source of cluster topology, servers.json is used by
+// default, and membership is maintained by master server in cluster mode
+func SetDiscovery(d cluster.Discovery) {
+	env.discovery = d
+}
+
 // EnableCluster enable cluster mode
 func EnableCluster() {
 	app.standalone = false
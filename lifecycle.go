@@ -0,0 +1,162 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"sync"
+
+	"github.com/lonnng/starx/session"
+)
+
+// HandshakeFunc represents the callback when client handshake, sys and user
+// are the payload sent by client, handshake will be rejected when error returned
+type HandshakeFunc func(s *session.Session, sys, user map[string]interface{}) error
+
+// lifecycle contains all session lifecycle callbacks registered by application
+var lifecycle = &struct {
+	sync.RWMutex
+	created          []func(*session.Session)
+	handshake        []HandshakeFunc
+	handshakeAck     []func(*session.Session)
+	bound            []func(*session.Session, int64)
+	heartbeatTimeout []func(*session.Session)
+	closed           []func(*session.Session)
+}{}
+
+func sessionCreated(s *session.Session) {
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.created {
+		cb(s)
+	}
+}
+
+// invoke handshake callbacks, stop at the first rejection
+func sessionHandshake(s *session.Session, sys, user map[string]interface{}) error {
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.handshake {
+		if err := cb(s, sys, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sessionHandshakeAck(s *session.Session) {
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.handshakeAck {
+		cb(s)
+	}
+}
+
+func sessionBound(s *session.Session, uid int64) {
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.bound {
+		cb(s, uid)
+	}
+}
+
+func sessionHeartbeatTimeout(s *session.Session) {
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.heartbeatTimeout {
+		cb(s)
+	}
+}
+
+func sessionClosed(s *session.Session) {
+	// mark session closed if no reason recorded
+	s.SetCloseReason(session.CloseReasonServerClose)
+
+	// closed session should not receive any group messages
+	leaveAllContainers(s)
+
+	lifecycle.RLock()
+	defer lifecycle.RUnlock()
+
+	for _, cb := range lifecycle.closed {
+		cb(s)
+	}
+}
+
+// OnSessionCreated register callback which will be called when a new session
+// created, frontend session created when client connected in, and backend
+// session created when the first request of frontend session arrived
+func OnSessionCreated(cb func(*session.Session)) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.created = append(lifecycle.created, cb)
+}
+
+// OnHandshake register callback which will be called when client handshake
+// request received, return an error to reject the client
+func OnHandshake(cb HandshakeFunc) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.handshake = append(lifecycle.handshake, cb)
+}
+
+// OnHandshakeAck register callback which will be called when client handshake
+// ack received, the session is ready for handling messages
+func OnHandshakeAck(cb func(*session.Session)) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.handshakeAck = append(lifecycle.handshakeAck, cb)
+}
+
+// OnSessionBound register callback which will be called after session bound
+// to a uid
+func OnSessionBound(cb func(s *session.Session, uid int64)) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.bound = append(lifecycle.bound, cb)
+}
+
+// OnHeartbeatTimeout register callback which will be called before session
+// closed for heartbeat timeout
+func OnHeartbeatTimeout(cb func(*session.Session)) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.heartbeatTimeout = append(lifecycle.heartbeatTimeout, cb)
+}
+
+// OnSessionClosed register callback which will be called when session closed,
+// the reason can be retrieved by session.CloseReason
+// Warning: session has been closed, messages can not be sent to it
+func OnSessionClosed(cb func(*session.Session)) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	lifecycle.closed = append(lifecycle.closed, cb)
+}
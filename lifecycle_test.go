@@ -0,0 +1,62 @@
+package starx
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/packet"
+	"github.com/lonnng/starx/session"
+)
+
+func TestOnHandshake_Reject(t *testing.T) {
+	defer func() {
+		lifecycle.handshake = nil
+		lifecycle.closed = nil
+	}()
+
+	OnHandshake(func(s *session.Session, sys, user map[string]interface{}) error {
+		if sys["version"] != "0.0.1" {
+			return errors.New("unsupported client version")
+		}
+		return nil
+	})
+
+	closed := make(chan session.CloseReason, 1)
+	OnSessionClosed(func(s *session.Session) {
+		closed <- s.CloseReason()
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go handler.handle(server)
+
+	hp, _ := packet.Pack(&packet.Packet{
+		Type: packet.Handshake,
+		Data: []byte(`{"sys":{"type":"test","version":"0.0.0"}}`),
+	})
+	if _, err := client.Write(hp); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 512)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _, err := packet.Unpack(buf[:n])
+	if err != nil || p == nil || p.Type != packet.Handshake {
+		t.Fatalf("unexpected handshake response: %v", p)
+	}
+
+	select {
+	case reason := <-closed:
+		if reason != session.CloseReasonHandshakeRejected {
+			t.Fatalf("unexpected close reason: %s", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session should be closed after handshake rejected")
+	}
+}
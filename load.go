@@ -0,0 +1,53 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/lonnng/starx/cluster/rpc"
+	"github.com/lonnng/starx/log"
+)
+
+// amount of sessions in current backend server, which is reported to other
+// servers as the load of current server
+var backendSessions int64
+
+// report load of current backend server to all servers connected in, which
+// is used by cluster.LeastLoaded balancer
+func reportLoad() {
+	data := []byte(strconv.FormatInt(atomic.LoadInt64(&backendSessions), 10))
+
+	transporter.RLock()
+	acceptors := make([]*acceptor, 0, len(transporter.acceptors))
+	for _, a := range transporter.acceptors {
+		acceptors = append(acceptors, a)
+	}
+	transporter.RUnlock()
+
+	for _, a := range acceptors {
+		resp := &rpc.Response{Kind: rpc.LoadReport, Data: data}
+		if err := rpc.WriteResponse(a.socket, resp); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
@@ -62,7 +62,7 @@ func logSite() string {
 }
 
 func writeLog(level string, v ...interface{}) {
-	logger.Printf(fmt.Sprintf("[%s] [%s] %s", level, logSite(), fmt.Sprint(v...)))
+	logger.Print(fmt.Sprintf("[%s] [%s] %s", level, logSite(), fmt.Sprint(v...)))
 }
 
 func writeLogf(level, format string, v ...interface{}) {
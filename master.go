@@ -0,0 +1,321 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
+	"github.com/lonnng/starx/log"
+	"github.com/lonnng/starx/timer"
+)
+
+const (
+	masterRegisterRoute   = "__Master.Register"
+	masterHeartbeatRoute  = "__Master.Heartbeat"
+	masterUnregisterRoute = "__Master.Unregister"
+)
+
+var ErrServerNotRegistered = errors.New("server not registered in master")
+
+// masterMember represents a server registered in master server
+type masterMember struct {
+	config   *cluster.ServerConfig
+	acceptor *acceptor // connection which the server registered or heartbeat from, nil when disconnected
+	lastTime time.Time // last heartbeat time
+}
+
+// members represents all servers registered in master server, master server
+// pushes the latest membership to all members when membership changed
+var members = &struct {
+	sync.Mutex
+	servers map[string]*masterMember
+}{servers: make(map[string]*masterMember)}
+
+// membership returns configs of master server and all members, sorted by id
+func membership() []*cluster.ServerConfig {
+	members.Lock()
+	defer members.Unlock()
+
+	return membershipLocked()
+}
+
+func membershipLocked() []*cluster.ServerConfig {
+	servers := []*cluster.ServerConfig{app.master}
+	for _, m := range members.servers {
+		servers = append(servers, m.config)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Id < servers[j].Id })
+	return servers
+}
+
+func registerMember(ac *acceptor, cfg *cluster.ServerConfig) {
+	members.Lock()
+	members.servers[cfg.Id] = &masterMember{config: cfg, acceptor: ac, lastTime: time.Now()}
+	members.Unlock()
+
+	log.Infof("server registered(%s)", cfg.String())
+	if _, err := cluster.Server(cfg.Id); err != nil {
+		cluster.Register(cfg)
+	} else {
+		cluster.UpdateServer(cfg)
+	}
+	pushMembership()
+}
+
+func heartbeatMember(ac *acceptor, id string) error {
+	members.Lock()
+	defer members.Unlock()
+
+	m, ok := members.servers[id]
+	if !ok {
+		return ErrServerNotRegistered
+	}
+	m.acceptor = ac
+	m.lastTime = time.Now()
+	return nil
+}
+
+// detach members connected by the closed acceptor, members are not removed
+// until heartbeat expired, because the connection may be re-established
+func detachMembers(a *acceptor) {
+	members.Lock()
+	defer members.Unlock()
+
+	for _, m := range members.servers {
+		if m.acceptor == a {
+			m.acceptor = nil
+		}
+	}
+}
+
+// remove members which match the condition, membership will be pushed to the
+// remaining members
+func removeMembers(match func(*masterMember) bool) {
+	members.Lock()
+	var removed []string
+	for id, m := range members.servers {
+		if match(m) {
+			removed = append(removed, id)
+			delete(members.servers, id)
+		}
+	}
+	members.Unlock()
+
+	if len(removed) == 0 {
+		return
+	}
+
+	for _, id := range removed {
+		log.Infof("server removed from cluster(Id: %s)", id)
+		cluster.RemoveServer(id)
+	}
+	pushMembership()
+}
+
+// remove members which have not sent heartbeat for three intervals
+func expireMembers() {
+	deadline := time.Now().Add(-3 * env.masterHeartbeatInterval)
+	removeMembers(func(m *masterMember) bool {
+		return m.lastTime.Before(deadline)
+	})
+}
+
+// push the latest membership to all members
+func pushMembership() {
+	members.Lock()
+	defer members.Unlock()
+
+	data, err := json.Marshal(membershipLocked())
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	for _, m := range members.servers {
+		if m.acceptor == nil {
+			continue
+		}
+		resp := &rpc.Response{Kind: rpc.ClusterUpdate, Data: data}
+		if err := rpc.WriteResponse(m.acceptor.socket, resp); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+}
+
+// Process membership requests from other servers in master server, returns
+// false when the request is not a master request
+func (rs *remoteService) processMasterRequest(ac *acceptor, rr *rpc.Request) bool {
+	var err error
+	switch rr.ServiceMethod {
+	case masterRegisterRoute:
+		cfg := &cluster.ServerConfig{}
+		if err = json.Unmarshal(rr.Data, cfg); err == nil {
+			registerMember(ac, cfg)
+		}
+	case masterHeartbeatRoute:
+		err = heartbeatMember(ac, string(rr.Data))
+	case masterUnregisterRoute:
+		id := string(rr.Data)
+		removeMembers(func(m *masterMember) bool { return m.config.Id == id })
+		return true
+	default:
+		return false
+	}
+
+	response := &rpc.Response{
+		ServiceMethod: rr.ServiceMethod,
+		Seq:           rr.Seq,
+		Kind:          rpc.RemoteResponse,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	} else if response.Data, err = json.Marshal(membership()); err != nil {
+		response.Error = err.Error()
+	}
+
+	if err := rpc.WriteResponse(ac.socket, response); err != nil {
+		log.Errorf(err.Error())
+	}
+	return true
+}
+
+// call master server and apply the membership replied
+func callMaster(route string, data []byte) error {
+	// master server may be removed when connection lost
+	if _, err := cluster.Server(app.master.Id); err != nil {
+		cluster.Register(app.master)
+	}
+
+	client, err := cluster.Client(app.master.Id)
+	if err != nil {
+		return err
+	}
+
+	// master server is unavailable when no reply in a heartbeat interval
+	ctx, cancel := context.WithTimeout(context.Background(), env.masterHeartbeatInterval)
+	defer cancel()
+
+	reply := new([]byte)
+	r := strings.SplitN(route, ".", 2)
+	if err := client.CallContext(ctx, rpc.Sys, r[0], r[1], 0, reply, data); err != nil {
+		return err
+	}
+
+	var servers []*cluster.ServerConfig
+	if err := json.Unmarshal(*reply, &servers); err != nil {
+		return err
+	}
+	cluster.SyncServers(servers)
+	return nil
+}
+
+func registerMaster() error {
+	data, err := json.Marshal(app.config)
+	if err != nil {
+		return err
+	}
+	return callMaster(masterRegisterRoute, data)
+}
+
+// send heartbeat to master server, register again when master server does
+// not know current server, e.g. master server restarted
+func heartbeatMaster() {
+	err := callMaster(masterHeartbeatRoute, []byte(app.config.Id))
+	if err == nil {
+		return
+	}
+
+	log.Infof("heartbeat to master failed(%s), register again", err.Error())
+	if err := registerMaster(); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+func unregisterMaster() {
+	client, err := cluster.Client(app.master.Id)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	if err := notify(client, masterUnregisterRoute, 0, []byte(app.config.Id)); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+func isMaster() bool {
+	return app.master != nil && app.config.Id == app.master.Id
+}
+
+// masterDiscovery represents the discovery in cluster mode, servers are
+// listed from the seed discovery on startup, which must contain master
+// server, then membership is maintained by master server. Master server
+// checks heartbeat of all members, and other servers register to master
+// server and send heartbeat periodically
+type masterDiscovery struct {
+	seed cluster.Discovery
+}
+
+func (d *masterDiscovery) List() ([]*cluster.ServerConfig, error) {
+	return d.seed.List()
+}
+
+// membership pushed by master server is synchronized by cluster package
+// directly, so the callback is ignored
+func (d *masterDiscovery) Watch(fn func([]*cluster.ServerConfig)) error {
+	return nil
+}
+
+func (d *masterDiscovery) Register(server *cluster.ServerConfig) error {
+	if isMaster() {
+		timer.Register(env.masterHeartbeatInterval, expireMembers)
+		return nil
+	}
+
+	timer.Register(env.masterHeartbeatInterval, heartbeatMaster)
+	return registerMaster()
+}
+
+// Reload synchronize the latest membership from master server, master server
+// pushes the membership to all members instead, servers.json is not reloaded
+// because membership is maintained by master server
+func (d *masterDiscovery) Reload() error {
+	if isMaster() {
+		pushMembership()
+		return nil
+	}
+	return registerMaster()
+}
+
+func (d *masterDiscovery) Deregister(id string) error {
+	if isMaster() {
+		return nil
+	}
+	unregisterMaster()
+	return nil
+}
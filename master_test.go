@@ -0,0 +1,90 @@
+package starx
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
+)
+
+// read responses from connection until a remote response received
+func readRemoteResponse(t *testing.T, conn net.Conn) *rpc.Response {
+	for {
+		resp, err := rpc.ReadResponse(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Kind == rpc.RemoteResponse {
+			return resp
+		}
+	}
+}
+
+func TestMaster_Membership(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ac := newAcceptor(1, c1)
+	cfg, _ := json.Marshal(&cluster.ServerConfig{Type: "test-member", Id: "test-member-1", Host: "127.0.0.1", Port: 12306})
+
+	go remote.processRequest(ac, &rpc.Request{ServiceMethod: masterRegisterRoute, Seq: 1, Data: cfg})
+	resp := readRemoteResponse(t, c2)
+
+	var servers []*cluster.ServerConfig
+	if err := json.Unmarshal(resp.Data, &servers); err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0].Id != app.master.Id || servers[1].Id != "test-member-1" {
+		t.Fatalf("unexpected membership: %+v", servers)
+	}
+	if _, err := cluster.Server("test-member-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	go remote.processRequest(ac, &rpc.Request{ServiceMethod: masterHeartbeatRoute, Seq: 2, Data: []byte("test-member-2")})
+	if resp := readRemoteResponse(t, c2); resp.Error != ErrServerNotRegistered.Error() {
+		t.Fatalf("unexpected heartbeat response: %+v", resp)
+	}
+
+	// member connection lost, removed until heartbeat expired
+	detachMembers(ac)
+	if len(membership()) != 2 {
+		t.Fatal("member should not be removed when connection lost")
+	}
+	members.Lock()
+	members.servers["test-member-1"].lastTime = time.Now().Add(-time.Hour)
+	members.Unlock()
+	expireMembers()
+	if len(membership()) != 1 {
+		t.Fail()
+	}
+	if _, err := cluster.Server("test-member-1"); err == nil {
+		t.Fatal("removed member should be removed from cluster")
+	}
+}
+
+func TestMasterDiscovery_Reload(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ac := newAcceptor(1, c1)
+	cfg, _ := json.Marshal(&cluster.ServerConfig{Type: "test-member", Id: "test-member-2", Host: "127.0.0.1", Port: 12307})
+	go remote.processRequest(ac, &rpc.Request{ServiceMethod: masterRegisterRoute, Seq: 1, Data: cfg})
+	readRemoteResponse(t, c2)
+	defer removeMembers(func(m *masterMember) bool { return m.config.Id == "test-member-2" })
+
+	// master server pushes membership to all members
+	go (&masterDiscovery{}).Reload()
+	resp, err := rpc.ReadResponse(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Kind != rpc.ClusterUpdate {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
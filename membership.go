@@ -0,0 +1,107 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"sync"
+
+	"github.com/lonnng/starx/session"
+)
+
+// LeaveReason represents the reason why a member left group or channel
+type LeaveReason int
+
+const (
+	LeaveReasonLeave         LeaveReason = iota // leave by application
+	LeaveReasonSessionClosed                    // member session closed
+	LeaveReasonClosed                           // group closed or all members left
+)
+
+var leaveReasonNames = map[LeaveReason]string{
+	LeaveReasonLeave:         "leave",
+	LeaveReasonSessionClosed: "session closed",
+	LeaveReasonClosed:        "closed",
+}
+
+func (r LeaveReason) String() string {
+	return leaveReasonNames[r]
+}
+
+// LeaveFunc represents the callback when member left group or channel
+type LeaveFunc func(s *session.Session, reason LeaveReason)
+
+// sessionContainer represents a group or channel, closed session will be
+// removed from all containers it joined
+type sessionContainer interface {
+	removeClosed(s *session.Session)
+}
+
+// memberships records the containers of every session joined
+var memberships = &struct {
+	sync.Mutex
+	joined map[*session.Session]map[sessionContainer]struct{}
+}{joined: make(map[*session.Session]map[sessionContainer]struct{})}
+
+// joinAliveContainer join the container only when session is alive, closed
+// session has left all containers and will never be removed again
+func joinAliveContainer(s *session.Session, c sessionContainer) bool {
+	memberships.Lock()
+	defer memberships.Unlock()
+
+	// close reason is recorded before leaving all containers
+	if s.CloseReason() != session.CloseReasonNone {
+		return false
+	}
+
+	cs, ok := memberships.joined[s]
+	if !ok {
+		cs = make(map[sessionContainer]struct{})
+		memberships.joined[s] = cs
+	}
+	cs[c] = struct{}{}
+	return true
+}
+
+func leaveContainer(s *session.Session, c sessionContainer) {
+	memberships.Lock()
+	defer memberships.Unlock()
+
+	cs, ok := memberships.joined[s]
+	if !ok {
+		return
+	}
+	delete(cs, c)
+	if len(cs) == 0 {
+		delete(memberships.joined, s)
+	}
+}
+
+// remove closed session from all containers it joined
+func leaveAllContainers(s *session.Session) {
+	memberships.Lock()
+	cs := memberships.joined[s]
+	delete(memberships.joined, s)
+	memberships.Unlock()
+
+	for c := range cs {
+		c.removeClosed(s)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/lonnng/starx/log"
+	"github.com/lonnng/starx/session"
+)
+
+// Topic is a hierarchical name separated by ".", e.g. "world.zone.12.chat",
+// subscription topic can contain wildcards, "*" matches exactly one segment,
+// e.g. "world.zone.*.chat", and "#" matches any remaining segments which must
+// be the last one, e.g. "world.#"
+const (
+	topicSeparator = "."
+	topicWildcard  = "*"
+	topicMultiWild = "#"
+)
+
+var (
+	ErrInvalidTopic      = errors.New("invalid topic")
+	ErrNotSubscribed     = errors.New("topic not subscribed")
+	ErrWildcardPublished = errors.New("can not publish to wildcard topic")
+)
+
+var pubsub = newTopicTree()
+
+type topicNode struct {
+	children    map[string]*topicNode
+	subscribers map[int64]*session.Session
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children:    make(map[string]*topicNode),
+		subscribers: make(map[int64]*session.Session),
+	}
+}
+
+func (n *topicNode) empty() bool {
+	return len(n.children) == 0 && len(n.subscribers) == 0
+}
+
+// topicTree represents all subscriptions in current server
+type topicTree struct {
+	sync.RWMutex
+	root   *topicNode
+	topics map[int64][]string // session id map to subscribed topics
+}
+
+func newTopicTree() *topicTree {
+	return &topicTree{
+		root:   newTopicNode(),
+		topics: make(map[int64][]string),
+	}
+}
+
+func splitTopic(topic string) ([]string, error) {
+	segs := strings.Split(topic, topicSeparator)
+	for i, seg := range segs {
+		if seg == "" {
+			return nil, ErrInvalidTopic
+		}
+		if seg == topicMultiWild && i != len(segs)-1 {
+			return nil, ErrInvalidTopic
+		}
+	}
+	return segs, nil
+}
+
+func (t *topicTree) subscribe(s *session.Session, topic string) error {
+	segs, err := splitTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	n := t.root
+	for _, seg := range segs {
+		child, ok := n.children[seg]
+		if !ok {
+			child = newTopicNode()
+			n.children[seg] = child
+		}
+		n = child
+	}
+	if _, ok := n.subscribers[s.ID]; !ok {
+		n.subscribers[s.ID] = s
+		t.topics[s.ID] = append(t.topics[s.ID], topic)
+	}
+	t.Unlock()
+
+	// closed session will never be removed by close hook
+	if !joinAliveContainer(s, t) {
+		t.removeClosed(s)
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+func (t *topicTree) unsubscribe(s *session.Session, topic string) error {
+	segs, err := splitTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	ok := t.removeLocked(s, segs)
+	if ok {
+		topics := t.topics[s.ID]
+		for i, tp := range topics {
+			if tp == topic {
+				topics = append(topics[:i], topics[i+1:]...)
+				break
+			}
+		}
+		if len(topics) == 0 {
+			delete(t.topics, s.ID)
+		} else {
+			t.topics[s.ID] = topics
+		}
+	}
+	remain := len(t.topics[s.ID])
+	t.Unlock()
+
+	if !ok {
+		return ErrNotSubscribed
+	}
+	if remain == 0 {
+		leaveContainer(s, t)
+	}
+	return nil
+}
+
+// remove subscriber from the node of segments, and prune empty nodes
+func (t *topicTree) removeLocked(s *session.Session, segs []string) bool {
+	path := []*topicNode{t.root}
+	n := t.root
+	for _, seg := range segs {
+		child, ok := n.children[seg]
+		if !ok {
+			return false
+		}
+		path = append(path, child)
+		n = child
+	}
+
+	if _, ok := n.subscribers[s.ID]; !ok {
+		return false
+	}
+	delete(n.subscribers, s.ID)
+
+	for i := len(segs) - 1; i >= 0 && path[i+1].empty(); i-- {
+		delete(path[i].children, segs[i])
+	}
+	return true
+}
+
+// remove all subscriptions of closed session, implement sessionContainer
+func (t *topicTree) removeClosed(s *session.Session) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, topic := range t.topics[s.ID] {
+		segs, _ := splitTopic(topic)
+		t.removeLocked(s, segs)
+	}
+	delete(t.topics, s.ID)
+}
+
+// match returns all subscribers of the topic, each session appears once even
+// though it subscribed multiple matched topics
+func (t *topicTree) match(topic string) ([]*session.Session, error) {
+	segs, err := splitTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segs {
+		if seg == topicWildcard || seg == topicMultiWild {
+			return nil, ErrWildcardPublished
+		}
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	matched := make(map[int64]*session.Session)
+	t.root.match(segs, matched)
+
+	sessions := make([]*session.Session, 0, len(matched))
+	for _, s := range matched {
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (n *topicNode) match(segs []string, matched map[int64]*session.Session) {
+	if multi, ok := n.children[topicMultiWild]; ok {
+		for id, s := range multi.subscribers {
+			matched[id] = s
+		}
+	}
+
+	if len(segs) == 0 {
+		for id, s := range n.subscribers {
+			matched[id] = s
+		}
+		return
+	}
+
+	if child, ok := n.children[segs[0]]; ok {
+		child.match(segs[1:], matched)
+	}
+	if child, ok := n.children[topicWildcard]; ok {
+		child.match(segs[1:], matched)
+	}
+}
+
+// Subscribe the topic for session, wildcards can be used in topic, all
+// subscriptions will be removed when session closed, ErrSessionClosed will be
+// returned when session has been closed
+func Subscribe(s *session.Session, topic string) error {
+	return pubsub.subscribe(s, topic)
+}
+
+// Unsubscribe the topic which subscribed by session
+func Unsubscribe(s *session.Session, topic string) error {
+	return pubsub.unsubscribe(s, topic)
+}
+
+// Publish push message to all sessions which subscribed topics matched,
+// published topic can not contain wildcards
+func Publish(topic, route string, v interface{}) error {
+	sessions, err := pubsub.match(topic)
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Type=Publish, Topic=%s, Route=%s, Data=%+v", topic, route, v)
+
+	return transporter.fanout(sessions, route, data)
+}
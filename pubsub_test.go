@@ -0,0 +1,91 @@
+package starx
+
+import (
+	"testing"
+
+	"github.com/lonnng/starx/session"
+)
+
+func TestTopicTree_Match(t *testing.T) {
+	tree := newTopicTree()
+
+	zone := session.New(&mockEntity{})
+	chat := session.New(&mockEntity{})
+	world := session.New(&mockEntity{})
+
+	tree.subscribe(zone, "world.zone.12.*")
+	tree.subscribe(chat, "world.zone.*.chat")
+	tree.subscribe(world, "world.#")
+	tree.subscribe(world, "world.zone.12.chat")
+
+	for _, invalid := range []string{"", "world..zone", "world.#.chat"} {
+		if err := tree.subscribe(zone, invalid); err != ErrInvalidTopic {
+			t.Fatalf("topic %q: expect invalid topic, got %v", invalid, err)
+		}
+	}
+
+	cases := map[string]int{
+		"world.zone.12.chat": 3,
+		"world.zone.12.move": 2,
+		"world.zone.13.chat": 2,
+		"world.zone.12":      1,
+		"world":              1,
+		"hello.world":        0,
+	}
+	for topic, count := range cases {
+		sessions, err := tree.match(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != count {
+			t.Fatalf("topic %q: expect %d subscribers, got %d", topic, count, len(sessions))
+		}
+	}
+
+	if _, err := tree.match("world.*"); err != ErrWildcardPublished {
+		t.Fail()
+	}
+
+	if err := tree.unsubscribe(chat, "world.zone.*.chat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.unsubscribe(chat, "world.zone.*.chat"); err != ErrNotSubscribed {
+		t.Fail()
+	}
+
+	tree.removeClosed(world)
+	if sessions, _ := tree.match("world.zone.12.chat"); len(sessions) != 1 {
+		t.Fail()
+	}
+
+	tree.removeClosed(zone)
+	if !tree.root.empty() || len(tree.topics) != 0 {
+		t.Fatal("expect empty tree")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	m := &mockEntity{}
+	s := session.New(m)
+	Subscribe(s, "world.zone.1.*")
+
+	Publish("world.zone.1.chat", "onChat", []byte("hello"))
+	if m.sent != 1 {
+		t.Fail()
+	}
+
+	transporter.closeSession(s)
+	Publish("world.zone.1.chat", "onChat", []byte("hello"))
+	if m.sent != 1 {
+		t.Fatal("subscription should be removed after session closed")
+	}
+
+	// closed session can not subscribe
+	if err := Subscribe(s, "world.zone.1.*"); err != ErrSessionClosed {
+		t.Fatalf("expect session closed, got %v", err)
+	}
+	Publish("world.zone.1.chat", "onChat", []byte("hello"))
+	if m.sent != 1 {
+		t.Fatal("closed session should not be subscribed")
+	}
+}
@@ -21,6 +21,7 @@
 package starx
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
 	"errors"
@@ -33,6 +34,7 @@ import (
 	"github.com/lonnng/starx/component"
 	"github.com/lonnng/starx/log"
 	"github.com/lonnng/starx/route"
+	"github.com/lonnng/starx/session"
 )
 
 var remote = newRemote()
@@ -83,6 +85,8 @@ func (rs *remoteService) handle(conn net.Conn) {
 	// message buffer
 	requestChan := make(chan *unhandledRequest, packetBufferSize)
 	endChan := make(chan bool, 1)
+	acceptor := transporter.createAcceptor(conn)
+
 	// all user logic will be handled in single goroutine
 	// synchronized in below routine
 	go func() {
@@ -90,6 +94,8 @@ func (rs *remoteService) handle(conn net.Conn) {
 			select {
 			case r := <-requestChan:
 				rs.processRequest(r.bs, r.rr)
+			case fn := <-acceptor.tasks:
+				fn()
 			case <-endChan:
 				close(requestChan)
 				return
@@ -97,12 +103,11 @@ func (rs *remoteService) handle(conn net.Conn) {
 		}
 	}()
 
-	acceptor := transporter.createAcceptor(conn)
 	transporter.dumpAcceptor()
-	tmp := make([]byte, 0) // save truncated data
-	buf := make([]byte, 512)
+	reader := bufio.NewReader(conn)
 	for {
-		n, err := conn.Read(buf)
+		// connection closed on malformed frame as well
+		rr, err := rpc.ReadRequest(reader)
 		if err != nil {
 			log.Infof("session closed(" + err.Error() + ")")
 			transporter.dumpAcceptor()
@@ -110,18 +115,7 @@ func (rs *remoteService) handle(conn net.Conn) {
 			endChan <- true
 			break
 		}
-		tmp = append(tmp, buf[:n]...)
-		// TODO
-		// Refactor this loop
-		// read all request from buffer, and send to handle queue
-		for {
-			rr := &rpc.Request{} // save decoded packet
-			if tmp, err = rr.UnmarshalMsg(tmp); err != nil {
-				break
-			} else {
-				requestChan <- &unhandledRequest{acceptor, rr}
-			}
-		}
+		requestChan <- &unhandledRequest{acceptor, rr}
 	}
 }
 
@@ -129,15 +123,73 @@ func isSessionClosedRequest(rr *rpc.Request) bool {
 	return rr.ServiceMethod == sessionClosedRoute
 }
 
+func isSessionSyncRequest(rr *rpc.Request) bool {
+	return rr.ServiceMethod == sessionSyncRoute
+}
+
+// Process uid directory requests from frontend servers, returns false when
+// the request is not a directory request
+func (rs *remoteService) processDirectoryRequest(ac *acceptor, rr *rpc.Request) bool {
+	switch rr.ServiceMethod {
+	case sessionBoundRoute, sessionUnboundRoute:
+		b := &uidBinding{}
+		if err := gobDecode(b, rr.Data); err != nil {
+			log.Errorf(err.Error())
+			return true
+		}
+		if rr.ServiceMethod == sessionBoundRoute {
+			directory.bind(ac, rr.Sid, b)
+		} else {
+			directory.unbind(ac, rr.Sid, b)
+		}
+	case uidMessageRoute:
+		m := &uidMessage{}
+		if err := gobDecode(m, rr.Data); err != nil {
+			log.Errorf(err.Error())
+			return true
+		}
+		if err := directory.deliver(m); err != nil {
+			log.Debugf("UID=%d, %s", m.Uid, err.Error())
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 func (rs *remoteService) processRequest(ac *acceptor, rr *rpc.Request) {
-	var session = ac.Session(rr.Sid)
+	if rs.processDirectoryRequest(ac, rr) || rs.processMasterRequest(ac, rr) {
+		return
+	}
+
+	// remote server reports its id after connected
+	if rr.ServiceMethod == serverIdentifyRoute {
+		ac.server.Store(string(rr.Data))
+		return
+	}
+
+	// zero sid represents session-less user rpc, which does not need a
+	// backend session
+	var session *session.Session
+	if rr.Sid != 0 || rr.Kind != rpc.User {
+		session = ac.Session(rr.Sid)
+	}
 
 	// session closed notify request
 	if isSessionClosedRequest(rr) {
+		session.SetCloseReason(closeReason(rr.Data))
 		transporter.closeSession(session)
 		return
 	}
 
+	// frontend session state synchronize request
+	if isSessionSyncRequest(rr) {
+		if err := transporter.syncSession(session, rr.Data); err != nil {
+			log.Errorf(err.Error())
+		}
+		return
+	}
+
 	var (
 		err      error
 		service  *component.Service
@@ -0,0 +1,120 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
+	routelib "github.com/lonnng/starx/route"
+	"github.com/lonnng/starx/session"
+)
+
+// RPC call remote method of the server type in route without session, e.g.
+// starx.RPC(ctx, "chat.RoomService.Count", &count, roomId), the server is
+// selected by the balancer of the type for every call, router set by
+// SetRouter is not used because there is no session to route
+func RPC(ctx context.Context, route string, reply interface{}, args ...interface{}) error {
+	return invokeRemote(route, reply, args, func(r *routelib.Route, data []byte) ([]byte, error) {
+		return cluster.CallContext(ctx, rpc.User, r, nil, data)
+	})
+}
+
+// RPCTo call remote method on the server specified by id without session,
+// server type of the route must be the type of the server
+func RPCTo(ctx context.Context, svrId, route string, reply interface{}, args ...interface{}) error {
+	return invokeRemote(route, reply, args, func(r *routelib.Route, data []byte) ([]byte, error) {
+		return cluster.CallServer(ctx, rpc.User, svrId, r, data)
+	})
+}
+
+// RPCResult represents the reply or error of a server in RPCAll
+type RPCResult struct {
+	Reply interface{} // pointer to a new value of the reply type
+	Err   error
+}
+
+// RPCAll call remote method on all servers of the server type in route
+// concurrently without session, e.g. count users in rooms of every chat
+// server, reply is only used to determine the type of replies. Every server
+// has its own timeout, the default timeout of the type is used when timeout
+// is zero, results are keyed by server id
+func RPCAll(ctx context.Context, route string, timeout time.Duration, reply interface{}, args ...interface{}) (map[string]*RPCResult, error) {
+	if reflect.TypeOf(reply).Kind() != reflect.Ptr {
+		return nil, session.ErrReplyShouldBePtr
+	}
+
+	r, data, err := encodeRemote(route, args)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := reflect.TypeOf(reply).Elem()
+	results := make(map[string]*RPCResult)
+	for id, ret := range cluster.CallAll(ctx, rpc.User, r, data, timeout) {
+		result := &RPCResult{Err: ret.Err}
+		if ret.Err == nil {
+			result.Reply = reflect.New(typ).Interface()
+			result.Err = gobDecode(result.Reply, ret.Data)
+		}
+		results[id] = result
+	}
+	return results, nil
+}
+
+// encode arguments of user rpc, and decode the reply of remote server
+func invokeRemote(route string, reply interface{}, args []interface{}, call func(*routelib.Route, []byte) ([]byte, error)) error {
+	if reflect.TypeOf(reply).Kind() != reflect.Ptr {
+		return session.ErrReplyShouldBePtr
+	}
+
+	r, data, err := encodeRemote(route, args)
+	if err != nil {
+		return err
+	}
+
+	ret, err := call(r, data)
+	if err != nil {
+		return err
+	}
+
+	return gobDecode(reply, ret)
+}
+
+func encodeRemote(route string, args []interface{}) (*routelib.Route, []byte, error) {
+	r, err := routelib.Decode(route)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if app.config.Type == r.ServerType {
+		return nil, nil, ErrRPCLocal
+	}
+
+	data, err := gobEncode(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, data, nil
+}
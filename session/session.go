@@ -1,9 +1,14 @@
 package session
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"errors"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lonnng/starx/log"
@@ -16,6 +21,9 @@ type NetworkEntity interface {
 	Push(session *Session, route string, v interface{}) error
 	Response(session *Session, v interface{}) error
 	Call(session *Session, route string, reply interface{}, args ...interface{}) error
+	CallContext(ctx context.Context, session *Session, route string, reply interface{}, args ...interface{}) error
+	Bind(session *Session, uid int64) error
+	Sync(session *Session) error
 	Close()
 }
 
@@ -26,6 +34,82 @@ var (
 	ErrReplyShouldBePtr = errors.New("reply should be a pointer")
 )
 
+// CloseReason represents the reason why a session was closed
+type CloseReason byte
+
+const (
+	CloseReasonNone              CloseReason = iota // session is not closed
+	CloseReasonClientEOF                            // connection closed by client or broken
+	CloseReasonHeartbeatTimeout                     // client heartbeat timeout
+	CloseReasonKick                                 // kicked by server
+	CloseReasonServerShutdown                       // server is shutting down
+	CloseReasonProtocolError                        // invalid packet or message received
+	CloseReasonHandshakeRejected                    // handshake rejected by application
+	CloseReasonServerClose                          // closed by application
+	CloseReasonSlowConsumer                         // send buffer overflowed with kick policy
+)
+
+var closeReasonNames = []string{
+	CloseReasonNone:              "None",
+	CloseReasonClientEOF:         "ClientEOF",
+	CloseReasonHeartbeatTimeout:  "HeartbeatTimeout",
+	CloseReasonKick:              "Kick",
+	CloseReasonServerShutdown:    "ServerShutdown",
+	CloseReasonProtocolError:     "ProtocolError",
+	CloseReasonHandshakeRejected: "HandshakeRejected",
+	CloseReasonServerClose:       "ServerClose",
+	CloseReasonSlowConsumer:      "SlowConsumer",
+}
+
+func (r CloseReason) String() string {
+	if int(r) < len(closeReasonNames) {
+		return closeReasonNames[r]
+	}
+	return "Unknown"
+}
+
+// ConnInfo represents the connection information of a frontend session, the
+// backend session contains a snapshot which synchronized from frontend server
+type ConnInfo struct {
+	RemoteAddr    string    // client remote address
+	Transport     string    // transport type, tcp or websocket
+	ConnectedAt   time.Time // time of client connected in
+	ClientType    string    // client type reported in handshake
+	ClientVersion string    // client version reported in handshake
+	BytesIn       int64     // bytes received from client
+	BytesOut      int64     // bytes sent to client
+	Dropped       int64     // messages dropped for send buffer overflow
+}
+
+var (
+	syncKeysLock sync.RWMutex        // protect syncKeys
+	syncKeys     = map[string]bool{} // session data keys shared between servers
+)
+
+// syncState represents the session state which will be synchronized between
+// frontend session and backend sessions
+type syncState struct {
+	Uid  int64
+	Data map[string]interface{}
+	Conn *ConnInfo
+}
+
+// SetSyncKeys set the session data keys which will be synchronized between
+// frontend server and backend servers together with the binding uid, value
+// type of those keys should be registered via gob.Register if it is not a
+// builtin type
+func SetSyncKeys(keys ...string) {
+	syncKeysLock.Lock()
+	defer syncKeysLock.Unlock()
+
+	syncKeys = make(map[string]bool)
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k != "" {
+			syncKeys[k] = true
+		}
+	}
+}
+
 // This session type as argument pass to Handler method, is a proxy session
 // for frontend session in frontend server or backend session in backend
 // server, correspond frontend session or backend session id as a field
@@ -33,13 +117,16 @@ var (
 //
 // This is user sessions, does not contain raw sockets information
 type Session struct {
-	ID        int64                  // session global unique id
-	Uid       int64                  // binding user id
-	Entity    NetworkEntity          // raw session id, agent in frontend server, or acceptor in backend server
-	LastID    uint                   // last request id
-	data      map[string]interface{} // session data store
-	lastTime  int64                  // last heartbeat time
-	serverIDs map[string]string      // map of server type -> server id
+	ID          int64                  // session global unique id
+	Uid         int64                  // binding user id
+	Entity      NetworkEntity          // raw session id, agent in frontend server, or acceptor in backend server
+	LastID      uint                   // last request id
+	data        map[string]interface{} // session data store
+	lastTime    int64                  // last heartbeat time
+	serverIDs   map[string]string      // map of server type -> server id
+	closeReason int32                  // reason of session closed, accessed atomically
+	conn        *ConnInfo              // connection information
+	connOwned   bool                   // conn is attached by the owner, and never replaced by synchronized data
 }
 
 // Create new session instance
@@ -61,6 +148,16 @@ func (s *Session) ServerID(svrType string) string {
 	return id
 }
 
+// Retrieve all server ids which the session has been routed to, map of
+// server type -> server id
+func (s *Session) ServerIDs() map[string]string {
+	ids := make(map[string]string, len(s.serverIDs))
+	for t, id := range s.serverIDs {
+		ids[t] = id
+	}
+	return ids
+}
+
 // Set server id of the special type, delete type when id empty
 func (s *Session) SetServerID(svrType, svrID string) {
 	svrType = strings.TrimSpace(svrType)
@@ -98,7 +195,71 @@ func (s *Session) Bind(uid int64) error {
 		log.Errorf("uid invalid: %d", uid)
 		return ErrIllegalUID
 	}
-	s.Uid = uid
+	if s.Entity == nil {
+		s.Uid = uid
+		return nil
+	}
+	return s.Entity.Bind(s, uid)
+}
+
+// Sync push the binding uid and the session data specified by SetSyncKeys
+// to the other side, frontend session will push to all backend servers
+// the session has been routed to, and backend session will push to the
+// frontend server
+func (s *Session) Sync() error {
+	return s.Entity.Sync(s)
+}
+
+// SyncData encode the binding uid and the synchronized session data
+func (s *Session) SyncData() ([]byte, error) {
+	st := &syncState{Uid: s.Uid, Data: make(map[string]interface{})}
+	if s.conn != nil {
+		info := s.ConnInfo()
+		st.Conn = &info
+	}
+
+	syncKeysLock.RLock()
+	for k := range syncKeys {
+		if v, ok := s.data[k]; ok {
+			st.Data[k] = v
+		}
+	}
+	syncKeysLock.RUnlock()
+
+	buf := bytes.NewBuffer([]byte(nil))
+	if err := gob.NewEncoder(buf).Encode(st); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ApplySyncData restore the binding uid and the synchronized session data
+// which encoded by SyncData, synchronized keys absent in data will be removed
+func (s *Session) ApplySyncData(data []byte) error {
+	st := &syncState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(st); err != nil {
+		return err
+	}
+
+	s.Uid = st.Uid
+	if st.Conn != nil && !s.connOwned {
+		s.conn = st.Conn
+	}
+
+	syncKeysLock.RLock()
+	for k := range syncKeys {
+		if _, ok := st.Data[k]; !ok {
+			delete(s.data, k)
+		}
+	}
+	syncKeysLock.RUnlock()
+
+	if s.data == nil {
+		s.data = make(map[string]interface{})
+	}
+	for k, v := range st.Data {
+		s.data[k] = v
+	}
 	return nil
 }
 
@@ -109,10 +270,59 @@ func (s *Session) Call(route string, reply interface{}, args ...interface{}) err
 	return s.Entity.Call(s, route, reply, args...)
 }
 
+// CallContext call remote server and wait for reply until context done, the
+// default timeout of remote server type is used when context has no deadline
+func (s *Session) CallContext(ctx context.Context, route string, reply interface{}, args ...interface{}) error {
+	if reflect.TypeOf(reply).Kind() != reflect.Ptr {
+		return ErrReplyShouldBePtr
+	}
+	return s.Entity.CallContext(ctx, s, route, reply, args...)
+}
+
 func (s *Session) Close() {
 	s.Entity.Close()
 }
 
+// ConnInfo returns a copy of the connection information, zero value will be
+// returned when session does not attach to a connection
+func (s *Session) ConnInfo() ConnInfo {
+	if s.conn == nil {
+		return ConnInfo{}
+	}
+
+	// counters are updated by the owner concurrently
+	return ConnInfo{
+		RemoteAddr:    s.conn.RemoteAddr,
+		Transport:     s.conn.Transport,
+		ConnectedAt:   s.conn.ConnectedAt,
+		ClientType:    s.conn.ClientType,
+		ClientVersion: s.conn.ClientVersion,
+		BytesIn:       atomic.LoadInt64(&s.conn.BytesIn),
+		BytesOut:      atomic.LoadInt64(&s.conn.BytesOut),
+		Dropped:       atomic.LoadInt64(&s.conn.Dropped),
+	}
+}
+
+// SetConnInfo attach the connection information to session, which is used
+// by framework, traffic counters should be updated atomically by the owner,
+// the attached information will not be replaced by synchronized data
+func (s *Session) SetConnInfo(info *ConnInfo) {
+	s.conn = info
+	s.connOwned = true
+}
+
+// CloseReason returns the reason why the session was closed, CloseReasonNone
+// will be returned when session is alive
+func (s *Session) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32(&s.closeReason))
+}
+
+// SetCloseReason record the close reason, which is set by framework before
+// session closed callbacks invoked, only the first reason will be recorded
+func (s *Session) SetCloseReason(reason CloseReason) {
+	atomic.CompareAndSwapInt32(&s.closeReason, int32(CloseReasonNone), int32(reason))
+}
+
 func (s *Session) Remove(key string) {
 	delete(s.data, key)
 }
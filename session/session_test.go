@@ -173,3 +173,99 @@ func TestSession_Restore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSession_SyncData(t *testing.T) {
+	SetSyncKeys("name", "level")
+	defer SetSyncKeys()
+
+	s := New(nil)
+	s.Bind(1000)
+	s.Set("name", "starx")
+	s.Set("private", 1)
+
+	data, err := s.SyncData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := New(nil)
+	s2.Set("level", 10)
+	s2.Set("private", 2)
+	if err := s2.ApplySyncData(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if s2.Uid != 1000 {
+		t.Fail()
+	}
+
+	if s2.String("name") != "starx" {
+		t.Fail()
+	}
+
+	// synchronized key absent in source session will be removed
+	if s2.HasKey("level") {
+		t.Fail()
+	}
+
+	// unsynchronized key keep untouched
+	if s2.Int("private") != 2 {
+		t.Fail()
+	}
+}
+
+func TestSession_CloseReason(t *testing.T) {
+	s := New(nil)
+	if s.CloseReason() != CloseReasonNone {
+		t.Fail()
+	}
+
+	s.SetCloseReason(CloseReasonKick)
+	s.SetCloseReason(CloseReasonClientEOF)
+	if s.CloseReason() != CloseReasonKick {
+		t.Fail()
+	}
+
+	if CloseReasonHeartbeatTimeout.String() != "HeartbeatTimeout" {
+		t.Fail()
+	}
+}
+
+func TestSession_ConnInfo(t *testing.T) {
+	s := New(nil)
+	if s.ConnInfo().RemoteAddr != "" {
+		t.Fail()
+	}
+
+	s.SetConnInfo(&ConnInfo{
+		RemoteAddr:    "127.0.0.1:3250",
+		Transport:     "websocket",
+		ClientType:    "js-websocket",
+		ClientVersion: "0.0.1",
+		BytesIn:       100,
+	})
+
+	data, err := s.SyncData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := New(nil)
+	if err := s2.ApplySyncData(data); err != nil {
+		t.Fatal(err)
+	}
+
+	info := s2.ConnInfo()
+	if info.RemoteAddr != "127.0.0.1:3250" || info.Transport != "websocket" ||
+		info.ClientVersion != "0.0.1" || info.BytesIn != 100 {
+		t.Fatalf("unexpected connection info: %+v", info)
+	}
+	// live connection information of the owner is not replaced
+	s2.SetConnInfo(&ConnInfo{RemoteAddr: "127.0.0.1:3251"})
+	if err := s2.ApplySyncData(data); err != nil {
+		t.Fatal(err)
+	}
+	if info := s2.ConnInfo(); info.RemoteAddr != "127.0.0.1:3251" {
+		t.Fatalf("connection information of the owner replaced: %+v", info)
+	}
+}
@@ -1,18 +1,64 @@
 package timer
 
 import (
+	"sync"
 	"time"
 )
 
 type Timer struct {
 	ticker     *time.Ticker
 	end        chan bool
+	stopOnce   sync.Once
 	limitCount int
 	counter    int
+
+	mu     sync.Mutex
+	exited bool   // timer stopped or fired count times
+	onStop func() // called after timer exited
 }
 
+// Stop the timer, it is safe to stop a timer more than once
 func (t *Timer) Stop() {
-	t.end <- true
+	t.stopOnce.Do(func() {
+		close(t.end)
+	})
+}
+
+// OnStop set the callback which will be called after the timer stopped or
+// fired count times, the callback will be called immediately when the timer
+// has been stopped
+func (t *Timer) OnStop(fn func()) {
+	t.mu.Lock()
+	if !t.exited {
+		t.onStop = fn
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	fn()
+}
+
+func (t *Timer) exit() {
+	t.ticker.Stop()
+
+	t.mu.Lock()
+	t.exited = true
+	fn := t.onStop
+	t.mu.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+}
+
+func (t *Timer) stopped() bool {
+	select {
+	case <-t.end:
+		return true
+	default:
+		return false
+	}
 }
 
 func Register(d time.Duration, fn func()) *Timer {
@@ -25,12 +71,16 @@ func Register(d time.Duration, fn func()) *Timer {
 		for {
 			select {
 			case <-t.ticker.C:
+				// stopped timer should not be fired, even though ticks pending
+				if t.stopped() {
+					break loop
+				}
 				fn()
 			case <-t.end:
-				t.ticker.Stop()
 				break loop
 			}
 		}
+		t.exit()
 	}()
 	return t
 }
@@ -48,16 +98,18 @@ func RegisterCount(d time.Duration, fn func(), count int) *Timer {
 			select {
 			case <-t.ticker.C:
 				t.counter++
-				if t.counter > t.limitCount {
-					t.ticker.Stop()
+				if t.stopped() || t.counter > t.limitCount {
 					break loop
 				}
 				fn()
+				if t.counter == t.limitCount {
+					break loop
+				}
 			case <-t.end:
-				t.ticker.Stop()
 				break loop
 			}
 		}
+		t.exit()
 	}()
 	return t
 }
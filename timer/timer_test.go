@@ -40,3 +40,51 @@ func TestRegisterCount(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTimer_Stop(t *testing.T) {
+	timer := RegisterCount(time.Millisecond, func() {}, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan bool, 1)
+	go func() {
+		timer.Stop()
+		timer.Stop()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop should not block")
+	}
+}
+
+func TestTimer_OnStop(t *testing.T) {
+	// fired count times
+	stopped := make(chan bool, 1)
+	timer := RegisterCount(time.Millisecond, func() {}, 1)
+	timer.OnStop(func() { stopped <- true })
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("callback should be called after fired count times")
+	}
+
+	// called immediately after timer stopped
+	timer.OnStop(func() { stopped <- true })
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("callback should be called immediately")
+	}
+
+	// stopped
+	timer = Register(time.Hour, func() {})
+	timer.OnStop(func() { stopped <- true })
+	timer.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("callback should be called after stopped")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lonnng/starx/session"
+	"github.com/lonnng/starx/timer"
+)
+
+// ErrSessionClosed represents timer attached to a closed session
+var ErrSessionClosed = errors.New("session closed")
+
+// scheduler represents the network entity which executes tasks in the
+// goroutine of handling session messages
+type scheduler interface {
+	schedule(fn func())
+}
+
+// sessionTimers records the timers of every session, which will be stopped
+// when session closed
+type sessionTimers struct {
+	sync.Mutex
+	timers map[*session.Session][]*timer.Timer
+}
+
+var timers = &sessionTimers{timers: make(map[*session.Session][]*timer.Timer)}
+
+func (st *sessionTimers) add(s *session.Session, t *timer.Timer) error {
+	st.Lock()
+	st.timers[s] = append(st.timers[s], t)
+	st.Unlock()
+
+	// session closed before the timer attached
+	if !joinAliveContainer(s, st) {
+		st.removeClosed(s)
+		return ErrSessionClosed
+	}
+
+	// timers fired or stopped are removed, so that one-shot timers of long
+	// lived session are not accumulated
+	t.OnStop(func() { st.remove(s, t) })
+	return nil
+}
+
+func (st *sessionTimers) remove(s *session.Session, t *timer.Timer) {
+	st.Lock()
+	defer st.Unlock()
+
+	ts, ok := st.timers[s]
+	if !ok {
+		return
+	}
+	for i, tt := range ts {
+		if tt == t {
+			ts = append(ts[:i], ts[i+1:]...)
+			break
+		}
+	}
+	if len(ts) > 0 {
+		st.timers[s] = ts
+		return
+	}
+	delete(st.timers, s)
+	leaveContainer(s, st)
+}
+
+// stop all timers of closed session, implement sessionContainer
+func (st *sessionTimers) removeClosed(s *session.Session) {
+	st.Lock()
+	ts := st.timers[s]
+	delete(st.timers, s)
+	st.Unlock()
+
+	for _, t := range ts {
+		t.Stop()
+	}
+}
+
+// wrap the timer function, which will be executed in the goroutine of
+// handling session messages
+func sessionTask(s *session.Session, fn func()) func() {
+	return func() {
+		if sch, ok := s.Entity.(scheduler); ok {
+			sch.schedule(fn)
+		} else {
+			fn()
+		}
+	}
+}
+
+// NewSessionTimer register a timer attached to the session, fn will be
+// executed in the same goroutine as the session handlers, and the timer will
+// be stopped when session closed, ErrSessionClosed will be returned with a
+// stopped timer when session has been closed
+func NewSessionTimer(s *session.Session, d time.Duration, fn func()) (*timer.Timer, error) {
+	t := timer.Register(d, sessionTask(s, fn))
+	return t, timers.add(s, t)
+}
+
+// NewSessionTimerCount register a timer attached to the session, which will
+// be executed count times at most
+func NewSessionTimerCount(s *session.Session, d time.Duration, fn func(), count int) (*timer.Timer, error) {
+	t := timer.RegisterCount(d, sessionTask(s, fn), count)
+	return t, timers.add(s, t)
+}
+
+// wrap the timer function, timer functions of the same group will never be
+// executed concurrently
+func (c *Group) timerTask(fn func()) func() {
+	return func() {
+		c.timerMu.Lock()
+		defer c.timerMu.Unlock()
+
+		if !c.isClosed() {
+			fn()
+		}
+	}
+}
+
+func (c *Group) addTimer(t *timer.Timer) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.isClosed() {
+		t.Stop()
+		return ErrClosedGroup
+	}
+	c.timers = append(c.timers, t)
+	t.OnStop(func() { c.removeTimer(t) })
+	return nil
+}
+
+func (c *Group) removeTimer(t *timer.Timer) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, tt := range c.timers {
+		if tt == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// NewTimer register a timer attached to the group, timer functions of the
+// same group are executed one by one, and timers will be stopped when group
+// closed
+func (c *Group) NewTimer(d time.Duration, fn func()) (*timer.Timer, error) {
+	t := timer.Register(d, c.timerTask(fn))
+	return t, c.addTimer(t)
+}
+
+// NewTimerCount register a timer attached to the group, which will be
+// executed count times at most
+func (c *Group) NewTimerCount(d time.Duration, fn func(), count int) (*timer.Timer, error) {
+	t := timer.RegisterCount(d, c.timerTask(fn), count)
+	return t, c.addTimer(t)
+}
+
+func (c *Group) stopTimers() {
+	c.Lock()
+	ts := c.timers
+	c.timers = nil
+	c.Unlock()
+
+	for _, t := range ts {
+		t.Stop()
+	}
+}
@@ -0,0 +1,120 @@
+package starx
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lonnng/starx/session"
+)
+
+func TestNewSessionTimer(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	// timer function should be scheduled to agent tasks
+	a := newAgent(c1)
+	if _, err := NewSessionTimer(a.session, time.Millisecond, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-a.tasks:
+	case <-time.After(time.Second):
+		t.Fatal("timer function should be scheduled")
+	}
+
+	var counter int32
+	s := session.New(&mockEntity{})
+	if _, err := NewSessionTimer(s, time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	transporter.closeSession(s)
+	time.Sleep(5 * time.Millisecond)
+	n := atomic.LoadInt32(&counter)
+	time.Sleep(10 * time.Millisecond)
+	if n == 0 || atomic.LoadInt32(&counter) != n {
+		t.Fatal("timer should be stopped after session closed")
+	}
+
+	// timer attached to closed session should be stopped immediately
+	if _, err := NewSessionTimerCount(s, time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}, 5); err != ErrSessionClosed {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&counter) != n {
+		t.Fatal("timer of closed session should not be executed")
+	}
+	timers.Lock()
+	_, ok := timers.timers[s]
+	timers.Unlock()
+	if ok {
+		t.Fatal("timer of closed session should not be recorded")
+	}
+}
+
+func TestNewSessionTimer_Remove(t *testing.T) {
+	s := session.New(&mockEntity{})
+	defer transporter.closeSession(s)
+
+	// fired and stopped timers are removed from session
+	if _, err := NewSessionTimerCount(s, time.Millisecond, func() {}, 1); err != nil {
+		t.Fatal(err)
+	}
+	tm, err := NewSessionTimer(s, time.Hour, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Stop()
+	time.Sleep(20 * time.Millisecond)
+
+	timers.Lock()
+	_, ok := timers.timers[s]
+	timers.Unlock()
+	if ok {
+		t.Fatal("fired and stopped timers should be removed")
+	}
+
+	g := NewGroup("test_timer_remove")
+	defer g.Close()
+	if _, err := g.NewTimerCount(time.Millisecond, func() {}, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	g.Lock()
+	n := len(g.timers)
+	g.Unlock()
+	if n != 0 {
+		t.Fatal("fired timers should be removed from group")
+	}
+}
+
+func TestGroup_NewTimer(t *testing.T) {
+	g := NewGroup("test_timer")
+
+	var counter int32
+	if _, err := g.NewTimer(time.Millisecond, func() {
+		atomic.AddInt32(&counter, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	g.Close()
+	time.Sleep(5 * time.Millisecond)
+	n := atomic.LoadInt32(&counter)
+	time.Sleep(10 * time.Millisecond)
+	if n == 0 || atomic.LoadInt32(&counter) != n {
+		t.Fatal("timer should be stopped after group closed")
+	}
+
+	if _, err := g.NewTimer(time.Millisecond, func() {}); err != ErrClosedGroup {
+		t.Fail()
+	}
+}
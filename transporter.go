@@ -22,6 +22,7 @@ package starx
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -34,11 +35,26 @@ import (
 	"github.com/lonnng/starx/session"
 )
 
-const sessionClosedRoute = "__Session.Closed"
+const (
+	sessionClosedRoute  = "__Session.Closed"
+	sessionSyncRoute    = "__Session.Sync"
+	serverIdentifyRoute = "__Server.Identify"
+)
 
 var (
 	ErrSessionOnNotify = errors.New("current session working on notify mode")
 	ErrSessionNotFound = errors.New("session not found")
+	ErrDuplicateLogin  = errors.New("uid has been bound by another session")
+)
+
+// DuplicateLoginPolicy represents the behavior when a uid which has been bound
+// by an online session is bound by another session in frontend server
+type DuplicateLoginPolicy byte
+
+const (
+	DuplicateRejectNew     DuplicateLoginPolicy = iota // new session bind failed with ErrDuplicateLogin
+	DuplicateKickOld                                   // old sessions will be kicked with reason
+	DuplicateAllowMultiple                             // all sessions keep online
 )
 
 var (
@@ -56,8 +72,7 @@ type transportService struct {
 	acceptorUid int64               // acceptor unique id
 	acceptors   map[int64]*acceptor // acceptor map
 
-	sessionCloseCbLock sync.RWMutex             // protect sessionCloseCb
-	sessionCloseCb     []func(*session.Session) // callback on session closed
+	uids map[int64][]*session.Session // uid map to bound sessions
 }
 
 // Create new t service
@@ -66,6 +81,7 @@ func newTransporter() *transportService {
 		agents:      make(map[int64]*agent),
 		acceptorUid: 0,
 		acceptors:   make(map[int64]*acceptor),
+		uids:        make(map[int64][]*session.Session),
 	}
 }
 
@@ -87,7 +103,7 @@ func (t *transportService) agent(id int64) (*agent, error) {
 
 	a, ok := t.agents[id]
 	if !ok {
-		return nil, errors.New("agent id: " + string(id) + " not exists!")
+		return nil, fmt.Errorf("agent id: %d not exists!", id)
 	}
 
 	return a, nil
@@ -112,7 +128,7 @@ func (t *transportService) acceptor(id int64) (*acceptor, error) {
 
 	rs, ok := t.acceptors[id]
 	if !ok || rs == nil {
-		return nil, errors.New("acceptor id: " + string(id) + " not exists!")
+		return nil, fmt.Errorf("acceptor id: %d not exists!", id)
 	}
 
 	return rs, nil
@@ -125,9 +141,32 @@ func (t *transportService) send(session *session.Session, data []byte) {
 	session.Entity.Send(data)
 }
 
+// broadcastSend send packet data of broadcasts, which never blocks on the
+// full send buffer of a slow client
+func (t *transportService) broadcastSend(session *session.Session, data []byte) {
+	if a, ok := session.Entity.(*agent); ok {
+		a.sendNonBlocking(data)
+		return
+	}
+	t.send(session, data)
+}
+
 // Push message to client
 // call by all package, the last argument was packaged message
 func (t *transportService) push(session *session.Session, route string, data []byte) error {
+	ep, err := encodePush(route, data)
+	if err != nil {
+		log.Errorf(err.Error())
+		return err
+	}
+
+	t.send(session, ep)
+	return nil
+}
+
+// encodePush encode push message to packet bytes, which can be shared by
+// multiple sessions, the bytes must not be modified after encoded
+func encodePush(route string, data []byte) ([]byte, error) {
 	m, err := message.Encode(&message.Message{
 		Type:  message.MessageType(message.Push),
 		Route: route,
@@ -135,8 +174,7 @@ func (t *transportService) push(session *session.Session, route string, data []b
 	})
 
 	if err != nil {
-		log.Errorf(err.Error())
-		return err
+		return nil, err
 	}
 
 	p := packet.Packet{
@@ -144,14 +182,7 @@ func (t *transportService) push(session *session.Session, route string, data []b
 		Length: len(m),
 		Data:   m,
 	}
-	ep, err := p.Pack()
-	if err != nil {
-		log.Errorf(err.Error())
-		return err
-	}
-
-	t.send(session, ep)
-	return nil
+	return p.Pack()
 }
 
 // Response message to client
@@ -195,23 +226,99 @@ func (t *transportService) broadcast(route string, data []byte) {
 		return
 	}
 
+	ep, err := encodePush(route, data)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
 	for _, s := range t.agents {
-		t.push(s.session, route, data)
+		t.broadcastSend(s.session, ep)
 	}
 }
 
+// frontendPush represents the frontend sessions of a broadcast, which will be
+// sent to the frontend server in a single response
+type frontendPush struct {
+	acceptor *acceptor
+	sids     []int64 // frontend session ids
+}
+
 // Multicast message to special agent ids
+// Push message to sessions, backend sessions are grouped by frontend server,
+// sessions from different connections of the same frontend server included,
+// so the message will be sent to each frontend server only once with member
+// list, and the packet of local sessions only encoded once
+func (t *transportService) fanout(sessions []*session.Session, route string, data []byte) error {
+	var (
+		err    error
+		ep     []byte
+		remote = make(map[interface{}]*frontendPush) // frontend server id or acceptor -> push
+	)
+
+	for _, s := range sessions {
+		if a, ok := s.Entity.(*acceptor); ok {
+			fsid, ok := a.frontendSid(s.ID)
+			if !ok {
+				continue
+			}
+
+			// acceptor is used when frontend server has not reported its id
+			var key interface{} = a
+			if id := a.serverID(); id != "" {
+				key = id
+			}
+			p, ok := remote[key]
+			if !ok {
+				p = &frontendPush{acceptor: a}
+				remote[key] = p
+			}
+			p.sids = append(p.sids, fsid)
+			continue
+		}
+
+		if ep == nil {
+			if ep, err = encodePush(route, data); err != nil {
+				log.Errorf(err.Error())
+				return err
+			}
+		}
+		t.broadcastSend(s, ep)
+	}
+
+	for _, p := range remote {
+		if e := p.acceptor.multicast(p.sids, route, data); e != nil {
+			log.Error(e.Error())
+			err = e
+		}
+	}
+
+	return err
+}
+
 func (t *transportService) multicast(aids []int64, route string, data []byte) {
+	ep, err := encodePush(route, data)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
 	t.RLock()
 	defer t.RUnlock()
 
 	for _, aid := range aids {
 		if agent, ok := t.agents[aid]; ok && agent != nil {
-			t.push(agent.session, route, data)
+			t.broadcastSend(agent.session, ep)
 		}
 	}
 }
 
+// Multicast push message to frontend sessions, which used by backend server
+// broadcasts, implement cluster.SessionManager
+func (t *transportService) Multicast(sids []int64, route string, data []byte) {
+	t.multicast(sids, route, data)
+}
+
 func (t *transportService) Session(sid int64) (*session.Session, error) {
 	t.RLock()
 	defer t.RUnlock()
@@ -223,36 +330,209 @@ func (t *transportService) Session(sid int64) (*session.Session, error) {
 	return a.session, nil
 }
 
-// Close session
-func (t *transportService) closeSession(session *session.Session) {
-	t.sessionCloseCbLock.RLock()
-	for _, cb := range t.sessionCloseCb {
-		if cb != nil {
-			cb(session)
+// Bind uid to session and maintain the uid index, duplicate login policy will
+// be applied in frontend server, bind 0 means unbind
+func (t *transportService) bind(s *session.Session, uid int64) error {
+	t.Lock()
+	if s.Uid == uid {
+		t.Unlock()
+		return nil
+	}
+
+	var kicked []*session.Session
+	if uid > 0 && app.config.IsFrontend && len(t.uids[uid]) > 0 {
+		switch env.duplicatePolicy {
+		case DuplicateRejectNew:
+			t.Unlock()
+			return ErrDuplicateLogin
+		case DuplicateKickOld:
+			kicked = t.uids[uid]
+			delete(t.uids, uid)
+		}
+	}
+
+	oldUid := s.Uid
+	t.unbindLocked(s)
+	s.Uid = uid
+	if uid > 0 {
+		t.uids[uid] = append(t.uids[uid], s)
+	}
+	t.Unlock()
+
+	// update cluster-wide uid directory
+	if app.config.IsFrontend {
+		if oldUid > 0 {
+			notifyBinding(s, sessionUnboundRoute, oldUid)
+		}
+		if uid > 0 {
+			notifyBinding(s, sessionBoundRoute, uid)
 		}
 	}
-	t.sessionCloseCbLock.RUnlock()
+
+	if uid > 0 {
+		sessionBound(s, uid)
+	}
+
+	for _, old := range kicked {
+		log.Infof("Kick session for duplicate login, Id=%d, Uid=%d", old.ID, uid)
+		t.Kick(old, env.duplicateKickReason)
+	}
+	return nil
+}
+
+// remove session from the uid index, caller should hold the lock
+func (t *transportService) unbindLocked(s *session.Session) {
+	if s.Uid < 1 {
+		return
+	}
+
+	sessions := t.uids[s.Uid]
+	for i, bs := range sessions {
+		if bs == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	if len(sessions) == 0 {
+		delete(t.uids, s.Uid)
+	} else {
+		t.uids[s.Uid] = sessions
+	}
+}
+
+// SyncSession apply the session state synchronized from the backend server,
+// and propagate it to other backend servers, the state of frontend session is
+// applied in the goroutine of handling messages, so it never races with
+// handlers, implement cluster.SessionManager
+func (t *transportService) SyncSession(s *session.Session, data []byte, from string) error {
+	a, ok := s.Entity.(*agent)
+	if !ok {
+		return t.syncSession(s, data)
+	}
+
+	a.schedule(func() {
+		if err := t.syncSession(s, data); err != nil {
+			log.Errorf(err.Error())
+			return
+		}
+		if err := cluster.SessionSync(s, from); err != nil {
+			log.Errorf(err.Error())
+		}
+	})
+	return nil
+}
+
+// Apply session state which synchronized from the other side, the uid index
+// will be updated when binding uid changed
+func (t *transportService) syncSession(s *session.Session, data []byte) error {
+	uid := s.Uid
+	if err := s.ApplySyncData(data); err != nil {
+		return err
+	}
+
+	if s.Uid == uid {
+		return nil
+	}
+
+	newUid := s.Uid
+	s.Uid = uid
+	err := t.bind(s, newUid)
+	if err == ErrDuplicateLogin {
+		// uid bound by backend server can not be rejected, kick the new
+		// session instead
+		t.Kick(s, env.duplicateKickReason)
+	}
+	return err
+}
+
+// Kick session with reason, only frontend session can be kicked
+func (t *transportService) Kick(s *session.Session, reason string) error {
+	if a, ok := s.Entity.(*agent); ok {
+		return a.Kick(reason)
+	}
+	s.SetCloseReason(session.CloseReasonKick)
+	s.Close()
+	return nil
+}
+
+// Retrieve all sessions bound to the uid
+func (t *transportService) sessionsByUID(uid int64) []*session.Session {
+	t.RLock()
+	defer t.RUnlock()
+
+	sessions := make([]*session.Session, len(t.uids[uid]))
+	copy(sessions, t.uids[uid])
+	return sessions
+}
+
+// Amount of bound users
+func (t *transportService) onlineCount() int {
+	t.RLock()
+	defer t.RUnlock()
+
+	return len(t.uids)
+}
+
+// Decode close reason from session closed request, the only byte of data is
+// the reason of frontend session
+func closeReason(data []byte) session.CloseReason {
+	if len(data) == 0 {
+		return session.CloseReasonClientEOF
+	}
+	return session.CloseReason(data[0])
+}
+
+// Close session
+func (t *transportService) closeSession(session *session.Session) {
+	sessionClosed(session)
 
 	t.Lock()
-	defer t.Unlock()
+	t.unbindLocked(session)
 
 	if app.config.IsFrontend {
 		if agent, ok := t.agents[session.Entity.ID()]; ok && (agent != nil) {
 			delete(t.agents, session.Entity.ID())
 		}
+		t.Unlock()
+
+		// notify backend servers without lock, which may dial
+		if session.Uid > 0 {
+			notifyBinding(session, sessionUnboundRoute, session.Uid)
+		}
 		// notify all backend server, current session has been closed.
 		cluster.SessionClosed(session)
 	} else {
+		defer t.Unlock()
+
 		if acceptor, ok := t.acceptors[session.Entity.ID()]; ok && (acceptor != nil) {
-			delete(acceptor.sessionMap, session.ID)
-			if fid, ok := acceptor.b2fMap[session.ID]; ok {
-				delete(acceptor.b2fMap, session.ID)
-				delete(acceptor.f2bMap, fid)
+			if acceptor.removeSession(session.ID) {
+				atomic.AddInt64(&backendSessions, -1)
 			}
 		}
 	}
 }
 
+// BackendConnected replay uid bindings of local sessions to the backend server,
+// whose uid directory lost the bindings recorded by previous connections, or
+// never received them, implement cluster.SessionManager
+func (t *transportService) BackendConnected(svrId string) {
+	if !app.config.IsFrontend {
+		return
+	}
+
+	t.RLock()
+	bindings := make(map[int64]int64) // session id -> uid
+	for uid, sessions := range t.uids {
+		for _, s := range sessions {
+			bindings[s.ID] = uid
+		}
+	}
+	t.RUnlock()
+
+	replayBindings(svrId, bindings)
+}
+
 func (t *transportService) removeAcceptor(a *acceptor) {
 	t.Lock()
 	defer t.Unlock()
@@ -269,24 +549,44 @@ func (t *transportService) heartbeat() {
 	dtu := dt.Unix()
 
 	for _, agent := range t.agents {
-		if agent.status != statusWorking {
+		if agent.getStatus() != statusWorking {
 			continue
 		}
 
 		if agent.lastTime < dtu {
 			log.Debugf("Session heartbeat timeout, LastTime=%d, Deadline=%d", agent.lastTime, dtu)
-			agent.Close()
+			sessionHeartbeatTimeout(agent.session)
+			agent.close(session.CloseReasonHeartbeatTimeout)
 			continue
 		}
 
-		if err := agent.Send(heartbeatPacket); err != nil {
+		// pending packets are being sent to client, heartbeat is unnecessary
+		err := agent.trySend(heartbeatPacket)
+		if err == ErrSendBufferFull {
+			continue
+		}
+		if err != nil {
 			log.Error(err)
-			agent.Close()
+			agent.close(session.CloseReasonClientEOF)
 			continue
 		}
 	}
 }
 
+// Close all sessions in current server when server shutdown
+func (t *transportService) shutdown() {
+	t.RLock()
+	agents := make([]*agent, 0, len(t.agents))
+	for _, a := range t.agents {
+		agents = append(agents, a)
+	}
+	t.RUnlock()
+
+	for _, a := range agents {
+		a.close(session.CloseReasonServerShutdown)
+	}
+}
+
 // Dump all agents
 func (t *transportService) dumpAgents() {
 	t.RLock()
@@ -309,15 +609,22 @@ func (t *transportService) dumpAcceptor() {
 	}
 }
 
-func (t *transportService) sessionClosedCallback(cb func(*session.Session)) {
-	t.sessionCloseCbLock.Lock()
-	defer t.sessionCloseCbLock.Unlock()
+// SessionByUID retrieve the latest bound session of the uid in current server
+func SessionByUID(uid int64) (*session.Session, error) {
+	sessions := transporter.sessionsByUID(uid)
+	if len(sessions) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return sessions[len(sessions)-1], nil
+}
 
-	t.sessionCloseCb = append(t.sessionCloseCb, cb)
+// SessionsByUID retrieve all bound sessions of the uid in current server, more
+// than one session exists only when DuplicateAllowMultiple policy used
+func SessionsByUID(uid int64) []*session.Session {
+	return transporter.sessionsByUID(uid)
 }
 
-// Callback when session closed
-// Waring: session has closed,
-func OnSessionClosed(cb func(*session.Session)) {
-	transporter.sessionClosedCallback(cb)
+// OnlineCount returns the amount of users bound to sessions in current server
+func OnlineCount() int {
+	return transporter.onlineCount()
 }
@@ -1,14 +1,112 @@
 package starx
 
 import (
+	"context"
+	"net"
 	"reflect"
 	"testing"
 
 	"github.com/lonnng/starx/packet"
+	"github.com/lonnng/starx/session"
 )
 
 func Test1(t *testing.T) {
-	if !reflect.DeepEqual(heartbeatPacket, append([]byte{packet.Heartbeat, 0x00, 0x00, 0x00})) {
+	if !reflect.DeepEqual(heartbeatPacket, []byte{packet.Heartbeat, 0x00, 0x00, 0x00}) {
 		t.Error("wrong heartbeat packet")
 	}
 }
+
+type mockEntity struct {
+	closed bool
+	sent   int
+}
+
+func (m *mockEntity) ID() int64                                                        { return 0 }
+func (m *mockEntity) Send([]byte) error                                                { m.sent++; return nil }
+func (m *mockEntity) Push(*session.Session, string, interface{}) error                 { return nil }
+func (m *mockEntity) Response(*session.Session, interface{}) error                     { return nil }
+func (m *mockEntity) Sync(*session.Session) error                                      { return nil }
+func (m *mockEntity) Close()                                                           { m.closed = true }
+func (m *mockEntity) Bind(s *session.Session, uid int64) error                         { return transporter.bind(s, uid) }
+func (m *mockEntity) Call(*session.Session, string, interface{}, ...interface{}) error { return nil }
+func (m *mockEntity) CallContext(context.Context, *session.Session, string, interface{}, ...interface{}) error {
+	return nil
+}
+
+func TestTransportService_Bind(t *testing.T) {
+	app.config.IsFrontend = true
+	policy := env.duplicatePolicy
+	if policy != DuplicateAllowMultiple {
+		t.Fatalf("multiple sessions should be allowed by default, got %d", policy)
+	}
+	defer func() {
+		app.config.IsFrontend = false
+		env.duplicatePolicy = policy
+	}()
+
+	m1, m2 := &mockEntity{}, &mockEntity{}
+	s1, s2 := session.New(m1), session.New(m2)
+
+	env.duplicatePolicy = DuplicateRejectNew
+	if err := s1.Bind(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Bind(100); err != ErrDuplicateLogin {
+		t.Fatalf("expect duplicate login error, got %v", err)
+	}
+	if s, err := SessionByUID(100); err != nil || s != s1 {
+		t.Fail()
+	}
+
+	env.duplicatePolicy = DuplicateAllowMultiple
+	if err := s2.Bind(100); err != nil {
+		t.Fatal(err)
+	}
+	if len(SessionsByUID(100)) != 2 || OnlineCount() != 1 {
+		t.Fail()
+	}
+	transporter.closeSession(s2)
+
+	env.duplicatePolicy = DuplicateKickOld
+	s3 := session.New(&mockEntity{})
+	if err := s3.Bind(100); err != nil {
+		t.Fatal(err)
+	}
+	if !m1.closed {
+		t.Error("old session should be kicked")
+	}
+	if s, err := SessionByUID(100); err != nil || s != s3 {
+		t.Fail()
+	}
+
+	transporter.closeSession(s3)
+	if _, err := SessionByUID(100); err != ErrSessionNotFound {
+		t.Fail()
+	}
+}
+
+func TestTransportService_SyncSession(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	a := newAgent(c1)
+	defer transporter.closeSession(a.session)
+
+	s := session.New(&mockEntity{})
+	s.Uid = 200
+	data, err := s.SyncData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// applied in the goroutine of handling messages
+	if err := transporter.SyncSession(a.session, data, "chat-1"); err != nil {
+		t.Fatal(err)
+	}
+	if a.session.Uid != 0 {
+		t.Fatal("session state should not be applied in the caller goroutine")
+	}
+	(<-a.tasks)()
+	if a.session.Uid != 200 {
+		t.Fatalf("expect uid 200, got %d", a.session.Uid)
+	}
+}
@@ -22,9 +22,14 @@ package starx
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 
+	"github.com/lonnng/starx/cluster"
+	"github.com/lonnng/starx/cluster/rpc"
 	"github.com/lonnng/starx/log"
+	routelib "github.com/lonnng/starx/route"
+	"github.com/lonnng/starx/session"
 	"os"
 )
 
@@ -52,6 +57,13 @@ func gobDecode(reply interface{}, data []byte) error {
 	return gob.NewDecoder(bytes.NewReader(data)).Decode(reply)
 }
 
+// call remote server of user rpc, which is invoked by session.Call
+func callRemote(ctx context.Context, session *session.Session, route string, reply interface{}, args ...interface{}) error {
+	return invokeRemote(route, reply, args, func(r *routelib.Route, data []byte) ([]byte, error) {
+		return cluster.CallContext(ctx, rpc.User, r, session, data)
+	})
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return err == nil || os.IsExist(err)